@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// AppliedOption describes an option that was applied to a Config.
+type AppliedOption struct {
+	// Name is the name of the option, e.g. "Transport".
+	Name string `json:"name"`
+
+	// Source is the file:line the option was constructed at, if known.
+	Source string `json:"source,omitempty"`
+
+	// Default is set if the option was applied as a default option.
+	Default bool `json:"default,omitempty"`
+}
+
+func (o AppliedOption) String() string {
+	if o.Source == "" {
+		return o.Name
+	}
+	return fmt.Sprintf("%s (%s)", o.Name, o.Source)
+}
+
+// ConflictError is returned when an option overrides a config field set by a
+// previous option.
+type ConflictError struct {
+	// Field is the name of the overridden Config field.
+	Field string
+
+	// Previous is the option that set the field, Current the option that
+	// overrode it.
+	Previous, Current AppliedOption
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("option %s overrides %s set by option %s", e.Current, e.Field, e.Previous)
+}
+
+// NamedOption returns an option that applies opt and, if that succeeds,
+// records it in Config.Applied under the given name and source.
+//
+// The option also becomes the owner of every config field it changes. If it
+// overrides a field owned by a previous option (e.g., replaces a non-empty list
+// instead of appending to it), it fails with a *ConflictError unless the config
+// is lenient, in which case the conflict is logged. Default options never own
+// fields, as other options are meant to override them.
+func NamedOption(name, source string, opt Option) Option {
+	return func(cfg *Config) error {
+		applied := AppliedOption{
+			Name:    name,
+			Source:  source,
+			Default: cfg.applyingDefaults > 0,
+		}
+
+		before := *cfg
+		if err := opt(cfg); err != nil {
+			return err
+		}
+		if !applied.Default {
+			if err := cfg.trackFields(&before, applied); err != nil {
+				return err
+			}
+		}
+
+		cfg.Applied = append(cfg.Applied, applied)
+		return nil
+	}
+}
+
+// ApplyDefaults applies the given options like Apply but records them as
+// default options.
+func (cfg *Config) ApplyDefaults(opts ...Option) error {
+	cfg.applyingDefaults++
+	defer func() { cfg.applyingDefaults-- }()
+	return cfg.Apply(opts...)
+}
+
+// trackFields records the given option as the owner of every field that
+// changed since before, checking for conflicts with the previous owners.
+func (cfg *Config) trackFields(before *Config, applied AppliedOption) error {
+	if cfg.owners == nil {
+		cfg.owners = make(map[string]AppliedOption)
+	}
+
+	oldV := reflect.ValueOf(before).Elem()
+	newV := reflect.ValueOf(cfg).Elem()
+	t := newV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// Skip unexported fields and our own bookkeeping.
+		if f.PkgPath != "" || f.Name == "Applied" {
+			continue
+		}
+		oldF, newF := oldV.Field(i), newV.Field(i)
+		if sameValue(oldF, newF) {
+			continue
+		}
+		if prev, ok := cfg.owners[f.Name]; ok && overrides(oldF, newF) {
+			err := &ConflictError{Field: f.Name, Previous: prev, Current: applied}
+			if !cfg.Lenient {
+				return err
+			}
+			log.Warning(err)
+		}
+		cfg.owners[f.Name] = applied
+	}
+	return nil
+}
+
+// overrides returns true if changing a field from oldV to newV discards the
+// old value. Setting a zero field and appending to a list don't.
+func overrides(oldV, newV reflect.Value) bool {
+	if isZero(oldV) {
+		return false
+	}
+	if oldV.Kind() == reflect.Slice {
+		if newV.Len() < oldV.Len() {
+			return true
+		}
+		return !sameValue(oldV, newV.Slice(0, oldV.Len()))
+	}
+	return true
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Func, reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return sameValue(v, reflect.Zero(v.Type()))
+}
+
+// sameValue compares config values. Unlike reflect.DeepEqual, functions and
+// pointers compare equal if they point to the same thing.
+func sameValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Func, reflect.Ptr, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	if !a.CanInterface() {
+		return true
+	}
+	return a.Interface() == b.Interface()
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/connmgr"
 	"github.com/libp2p/go-libp2p-core/crypto"
@@ -13,14 +14,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/pnet"
 	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/transport"
 
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	relay "github.com/libp2p/go-libp2p/p2p/host/relay"
 	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+	"github.com/libp2p/go-libp2p/p2p/net/resolver"
 
 	circuit "github.com/libp2p/go-libp2p-circuit"
 	discovery "github.com/libp2p/go-libp2p-discovery"
-	swarm "github.com/libp2p/go-libp2p-swarm"
 	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
 
 	logging "github.com/ipfs/go-log"
@@ -63,6 +65,7 @@ type Config struct {
 	RelayOpts   []circuit.RelayOpt
 
 	ListenAddrs  []ma.Multiaddr
+	ListenStrict bool
 	AddrsFactory bhost.AddrsFactory
 	Filters      *filter.Filters
 
@@ -70,31 +73,60 @@ type Config struct {
 	NATManager  NATManagerC
 	Peerstore   peerstore.Peerstore
 	Reporter    metrics.Reporter
+	Network     NetworkC
 
 	DisablePing bool
 
+	// NegotiationTimeout is the timeout for inbound protocol negotiation
+	// (see bhost.HostOpts.NegotiationTimeout).
+	NegotiationTimeout time.Duration
+
+	// DialRanker orders and staggers dials (see bhost.HostOpts.DialRanker).
+	DialRanker bhost.DialRanker
+
+	// DNSResolver resolves DNS addresses before dialing (see
+	// bhost.HostOpts.DNSResolver).
+	DNSResolver resolver.Backend
+
+	// MaxAddressResolution, MaxResolveDepth and MaxResolveFanout limit the
+	// resolution of DNS addresses (see the bhost.HostOpts fields).
+	MaxAddressResolution int
+	MaxResolveDepth      int
+	MaxResolveFanout     int
+
 	Routing RoutingC
 
 	EnableAutoRelay bool
 	StaticRelays    []peer.AddrInfo
+
+	Services []ServiceC
+
+	// Lenient makes options overriding each other log a warning instead of
+	// failing (see NamedOption).
+	Lenient bool
+
+	// Applied lists the options applied to the config, in order (see
+	// NamedOption).
+	Applied []AppliedOption
+
+	applyingDefaults int
+	// owners maps config fields to the option that last set them.
+	owners map[string]AppliedOption
 }
 
 // NewNode constructs a new libp2p Host from the Config.
 //
 // This function consumes the config. Do not reuse it (really!).
 func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
-	// Check this early. Prevents us from even *starting* without verifying this.
-	if pnet.ForcePrivateNetwork && len(cfg.PSK) == 0 {
-		log.Error("tried to create a libp2p node with no Private" +
-			" Network Protector but usage of Private Networks" +
-			" is forced by the enviroment")
-		// Note: This is *also* checked the upgrader itself so it'll be
-		// enforced even *if* you don't use the libp2p constructor.
-		return nil, pnet.ErrNotInPrivateNetwork
-	}
-
-	if cfg.PeerKey == nil {
-		return nil, fmt.Errorf("no peer key specified")
+	// Check this early. Prevents us from even *starting* with incompatible
+	// options.
+	if errs := cfg.checkOptions(); len(errs) > 0 {
+		if errs[0] == pnet.ErrNotInPrivateNetwork {
+			log.Error("tried to create a libp2p node with no Private" +
+				" Network Protector but usage of Private Networks" +
+				" is forced by the enviroment")
+		}
+		return nil, errs[0]
 	}
 
 	// Obtain Peer ID from public key
@@ -103,10 +135,6 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 		return nil, err
 	}
 
-	if cfg.Peerstore == nil {
-		return nil, fmt.Errorf("no peerstore specified")
-	}
-
 	if err := cfg.Peerstore.AddPrivKey(pid, cfg.PeerKey); err != nil {
 		return nil, err
 	}
@@ -114,22 +142,39 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 		return nil, err
 	}
 
-	// TODO: Make the swarm implementation configurable.
-	swrm := swarm.NewSwarm(ctx, pid, cfg.Peerstore, cfg.Reporter)
-	if cfg.Filters != nil {
-		swrm.Filters = cfg.Filters
+	filters := cfg.Filters
+	if filters == nil {
+		filters = filter.NewFilters()
 	}
 
-	h, err := bhost.NewHost(ctx, swrm, &bhost.HostOpts{
-		ConnManager:  cfg.ConnManager,
-		AddrsFactory: cfg.AddrsFactory,
-		NATManager:   cfg.NATManager,
-		EnablePing:   !cfg.DisablePing,
-		UserAgent:    cfg.UserAgent,
+	netw, err := makeNetwork(ctx, cfg.Network, &NetworkArgs{
+		PeerID:    pid,
+		PrivKey:   cfg.PeerKey,
+		Peerstore: cfg.Peerstore,
+		Reporter:  cfg.Reporter,
+		Filters:   filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+	netCtx := networkContext(netw)
+
+	h, err := bhost.NewHost(ctx, netw, &bhost.HostOpts{
+		ConnManager:          cfg.ConnManager,
+		AddrsFactory:         cfg.AddrsFactory,
+		NATManager:           cfg.NATManager,
+		NegotiationTimeout:   cfg.NegotiationTimeout,
+		DialRanker:           cfg.DialRanker,
+		DNSResolver:          cfg.DNSResolver,
+		MaxAddressResolution: cfg.MaxAddressResolution,
+		MaxResolveDepth:      cfg.MaxResolveDepth,
+		MaxResolveFanout:     cfg.MaxResolveFanout,
+		EnablePing:           !cfg.DisablePing,
+		UserAgent:            cfg.UserAgent,
 	})
 
 	if err != nil {
-		swrm.Close()
+		netw.Close()
 		return nil, err
 	}
 
@@ -146,7 +191,7 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 
 	upgrader := new(tptu.Upgrader)
 	upgrader.PSK = cfg.PSK
-	upgrader.Filters = swrm.Filters
+	upgrader.Filters = filters
 	if cfg.Insecure {
 		upgrader.Secure = makeInsecureTransport(pid, cfg.PeerKey)
 	} else {
@@ -168,29 +213,29 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 		h.Close()
 		return nil, err
 	}
-	for _, t := range tpts {
-		err = swrm.AddTransport(t)
-		if err != nil {
+	if len(tpts) > 0 {
+		tptNet, ok := netw.(transport.TransportNetwork)
+		if !ok {
 			h.Close()
-			return nil, err
+			return nil, fmt.Errorf("cannot add transports; %T is not a transport network", netw)
+		}
+		for _, t := range tpts {
+			err = tptNet.AddTransport(t)
+			if err != nil {
+				h.Close()
+				return nil, err
+			}
 		}
 	}
 
 	if cfg.Relay {
-		err := circuit.AddRelayTransport(swrm.Context(), h, upgrader, cfg.RelayOpts...)
+		err := circuit.AddRelayTransport(netCtx, h, upgrader, cfg.RelayOpts...)
 		if err != nil {
 			h.Close()
 			return nil, err
 		}
 	}
 
-	// TODO: This method succeeds if listening on one address succeeds. We
-	// should probably fail if listening on *any* addr fails.
-	if err := h.Network().Listen(cfg.ListenAddrs...); err != nil {
-		h.Close()
-		return nil, err
-	}
-
 	// Configure routing and autorelay
 	var router routing.PeerRouting
 	if cfg.Routing != nil {
@@ -202,21 +247,10 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 	}
 
 	if cfg.EnableAutoRelay {
-		if !cfg.Relay {
-			h.Close()
-			return nil, fmt.Errorf("cannot enable autorelay; relay is not enabled")
-		}
-
-		hop := false
-		for _, opt := range cfg.RelayOpts {
-			if opt == circuit.OptHop {
-				hop = true
-				break
-			}
-		}
+		hop := isRelayHop(cfg.RelayOpts)
 
 		if !hop && len(cfg.StaticRelays) > 0 {
-			_ = relay.NewAutoRelay(swrm.Context(), h, nil, router, cfg.StaticRelays)
+			_ = relay.NewAutoRelay(netCtx, h, nil, router, cfg.StaticRelays)
 		} else {
 			if router == nil {
 				h.Close()
@@ -235,18 +269,43 @@ func (cfg *Config) NewNode(ctx context.Context) (host.Host, error) {
 				// advertise ourselves
 				relay.Advertise(ctx, discovery)
 			} else {
-				_ = relay.NewAutoRelay(swrm.Context(), h, discovery, router, cfg.StaticRelays)
+				_ = relay.NewAutoRelay(netCtx, h, discovery, router, cfg.StaticRelays)
 			}
 		}
 	}
 
+	var ho host.Host = h
+	if router != nil {
+		ho = routed.Wrap(h, router)
+	}
+
+	// Construct the user services once routing is configured, as they get
+	// the routed host, but before listening so the stream handlers they
+	// register are in place for the first inbound connection. They're closed
+	// (in reverse order) before the network when the host shuts down.
+	if err := startServices(h.Process(), ho, cfg.Services); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	// Listen succeeds if listening on one address succeeds. In strict mode,
+	// we fail if listening on *any* addr fails.
+	if cfg.ListenStrict {
+		err = listenStrict(h.Network(), cfg.ListenAddrs)
+	} else {
+		err = h.Network().Listen(cfg.ListenAddrs...)
+	}
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	h.Description = cfg.describe(netw, tpts)
+
 	// start the host background tasks
 	h.Start()
 
-	if router != nil {
-		return routed.Wrap(h, router), nil
-	}
-	return h, nil
+	return ho, nil
 }
 
 // Option is a libp2p config option that can be given to the libp2p constructor
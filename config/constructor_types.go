@@ -5,6 +5,7 @@ import (
 	"reflect"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/network"
@@ -28,6 +29,7 @@ var (
 	privKeyType   = reflect.TypeOf((*crypto.PrivKey)(nil)).Elem()
 	pubKeyType    = reflect.TypeOf((*crypto.PubKey)(nil)).Elem()
 	pstoreType    = reflect.TypeOf((*peerstore.Peerstore)(nil)).Elem()
+	eventBusType  = reflect.TypeOf((*event.Bus)(nil)).Elem()
 
 	// concrete types
 	peerIDType   = reflect.TypeOf((peer.ID)(""))
@@ -48,6 +50,7 @@ var argTypes = map[reflect.Type]constructor{
 	privKeyType:  func(h host.Host, u *tptu.Upgrader) interface{} { return h.Peerstore().PrivKey(h.ID()) },
 	pubKeyType:   func(h host.Host, u *tptu.Upgrader) interface{} { return h.Peerstore().PubKey(h.ID()) },
 	pstoreType:   func(h host.Host, u *tptu.Upgrader) interface{} { return h.Peerstore() },
+	eventBusType: func(h host.Host, u *tptu.Upgrader) interface{} { return h.EventBus() },
 }
 
 func newArgTypeSet(types ...reflect.Type) map[reflect.Type]constructor {
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/transport"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Description is a JSON-marshalable summary of the configuration a node was
+// constructed with. The libp2p constructor attaches it to the host (see
+// basichost.HostDescription).
+type Description struct {
+	Transports     []TransportDescription `json:"transports"`
+	Muxers         []string               `json:"muxers"`
+	Security       []string               `json:"security"`
+	Insecure       bool                   `json:"insecure,omitempty"`
+	PrivateNetwork bool                   `json:"privateNetwork,omitempty"`
+
+	ListenAddrs  []ma.Multiaddr `json:"listenAddrs"`
+	ListenStrict bool           `json:"listenStrict,omitempty"`
+
+	Relay        RelayDescription `json:"relay"`
+	AutoRelay    bool             `json:"autoRelay,omitempty"`
+	StaticRelays []peer.ID        `json:"staticRelays,omitempty"`
+	Routing      bool             `json:"routing,omitempty"`
+
+	Network     string `json:"network"`
+	Peerstore   string `json:"peerstore"`
+	ConnManager string `json:"connManager,omitempty"`
+	NATManager  bool   `json:"natManager,omitempty"`
+	Ping        bool   `json:"ping"`
+	UserAgent   string `json:"userAgent,omitempty"`
+
+	// Options lists the options applied to the config, in order.
+	Options []AppliedOption `json:"options"`
+}
+
+// TransportDescription describes a constructed transport.
+type TransportDescription struct {
+	// Type is the transport's Go type.
+	Type string `json:"type"`
+	// Protocols are the multiaddr protocols the transport handles.
+	Protocols []string `json:"protocols"`
+}
+
+// RelayDescription describes the relay transport configuration.
+type RelayDescription struct {
+	Enabled   bool `json:"enabled"`
+	Hop       bool `json:"hop,omitempty"`
+	Active    bool `json:"active,omitempty"`
+	Discovery bool `json:"discovery,omitempty"`
+}
+
+// describe summarizes the config given the network and transports constructed
+// from it.
+func (cfg *Config) describe(n network.Network, tpts []transport.Transport) *Description {
+	d := &Description{
+		Muxers:         make([]string, 0, len(cfg.Muxers)),
+		Security:       make([]string, 0, len(cfg.SecurityTransports)),
+		Insecure:       cfg.Insecure,
+		PrivateNetwork: len(cfg.PSK) > 0,
+		ListenAddrs:    cfg.ListenAddrs,
+		ListenStrict:   cfg.ListenStrict,
+		AutoRelay:      cfg.EnableAutoRelay,
+		Routing:        cfg.Routing != nil,
+		Network:        fmt.Sprintf("%T", n),
+		Peerstore:      fmt.Sprintf("%T", cfg.Peerstore),
+		NATManager:     cfg.NATManager != nil,
+		Ping:           !cfg.DisablePing,
+		UserAgent:      cfg.UserAgent,
+		Options:        cfg.Applied,
+	}
+
+	d.Transports = make([]TransportDescription, 0, len(tpts))
+	for _, t := range tpts {
+		td := TransportDescription{Type: fmt.Sprintf("%T", t)}
+		for _, p := range t.Protocols() {
+			td.Protocols = append(td.Protocols, ma.ProtocolWithCode(p).Name)
+		}
+		d.Transports = append(d.Transports, td)
+	}
+	for _, m := range cfg.Muxers {
+		d.Muxers = append(d.Muxers, m.ID)
+	}
+	for _, s := range cfg.SecurityTransports {
+		d.Security = append(d.Security, s.ID)
+	}
+
+	if cfg.Relay {
+		d.Relay.Enabled = true
+		for _, opt := range cfg.RelayOpts {
+			switch opt {
+			case circuit.OptHop:
+				d.Relay.Hop = true
+			case circuit.OptActive:
+				d.Relay.Active = true
+			case circuit.OptDiscovery:
+				d.Relay.Discovery = true
+			}
+		}
+	}
+	for _, pi := range cfg.StaticRelays {
+		d.StaticRelays = append(d.StaticRelays, pi.ID)
+	}
+	if cfg.ConnManager != nil {
+		d.ConnManager = fmt.Sprintf("%T", cfg.ConnManager)
+	}
+
+	return d
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ListenFailure describes a listen address that couldn't be bound.
+type ListenFailure struct {
+	Addr ma.Multiaddr
+	Err  error
+}
+
+// ListenError is returned by NewNode in strict listen mode (see
+// Config.ListenStrict) when one or more listen addresses couldn't be bound.
+type ListenError struct {
+	Failures []ListenFailure
+}
+
+func (e *ListenError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("%s: %s", f.Addr, f.Err)
+	}
+	return fmt.Sprintf("failed to listen on %d of the configured addresses: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// listenStrict listens on every address individually, returning a *ListenError
+// listing all addresses that couldn't be bound.
+func listenStrict(n network.Network, addrs []ma.Multiaddr) error {
+	var failures []ListenFailure
+	for _, a := range addrs {
+		if err := n.Listen(a); err != nil {
+			failures = append(failures, ListenFailure{Addr: a, Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return &ListenError{Failures: failures}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+
+	goprocessctx "github.com/jbenet/goprocess/context"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	filter "github.com/libp2p/go-maddr-filter"
+)
+
+// NetworkC is a network constructor. You probably won't ever implement this
+// function interface directly. Instead, pass your network constructor to
+// NetworkConstructor.
+type NetworkC func(ctx context.Context, args *NetworkArgs) (network.Network, error)
+
+// NetworkArgs holds the values a network constructor may ask for.
+type NetworkArgs struct {
+	PeerID    peer.ID
+	PrivKey   crypto.PrivKey
+	Peerstore peerstore.Peerstore
+	Reporter  metrics.Reporter
+	Filters   *filter.Filters
+}
+
+type networkArgConstructor func(ctx context.Context, args *NetworkArgs) interface{}
+
+var (
+	contextType  = reflect.TypeOf((*context.Context)(nil)).Elem()
+	reporterType = reflect.TypeOf((*metrics.Reporter)(nil)).Elem()
+)
+
+var networkArgTypes = map[reflect.Type]networkArgConstructor{
+	contextType:  func(ctx context.Context, _ *NetworkArgs) interface{} { return ctx },
+	peerIDType:   func(_ context.Context, a *NetworkArgs) interface{} { return a.PeerID },
+	privKeyType:  func(_ context.Context, a *NetworkArgs) interface{} { return a.PrivKey },
+	pubKeyType:   func(_ context.Context, a *NetworkArgs) interface{} { return a.PrivKey.GetPublic() },
+	pstoreType:   func(_ context.Context, a *NetworkArgs) interface{} { return a.Peerstore },
+	reporterType: func(_ context.Context, a *NetworkArgs) interface{} { return a.Reporter },
+	filtersType:  func(_ context.Context, a *NetworkArgs) interface{} { return a.Filters },
+}
+
+// NetworkConstructor uses reflection to turn a function that constructs a
+// network into a NetworkC.
+//
+// You can pass either a constructed network (something that implements
+// `network.Network`) or a function that takes any of:
+//
+// * A context.
+// * The local peer ID.
+// * A private key.
+// * A public key.
+// * A Peerstore.
+// * A bandwidth reporter (metrics.Reporter).
+// * An address filter.
+//
+// And returns a type implementing network.Network and, optionally, an error
+// (as the second argument).
+//
+// Transports are added to the network by NewNode, so the network must
+// implement transport.TransportNetwork unless the node is configured without
+// transports.
+func NetworkConstructor(n interface{}) (NetworkC, error) {
+	// Already constructed?
+	if net, ok := n.(network.Network); ok {
+		return func(_ context.Context, _ *NetworkArgs) (network.Network, error) {
+			return net, nil
+		}, nil
+	}
+
+	v := reflect.ValueOf(n)
+	// avoid panicing on nil/zero value.
+	if v == (reflect.Value{}) {
+		return nil, fmt.Errorf("expected a network or network constructor, got a %T", n)
+	}
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("expected a network or network constructor, got a %T", n)
+	}
+
+	if err := checkReturnType(t, networkType); err != nil {
+		return nil, err
+	}
+
+	argConstructors := make([]networkArgConstructor, t.NumIn())
+	for i := range argConstructors {
+		argType := t.In(i)
+		c, ok := networkArgTypes[argType]
+		if !ok {
+			return nil, fmt.Errorf("argument %d has an unexpected type %s", i, argType.Name())
+		}
+		argConstructors[i] = c
+	}
+
+	return func(ctx context.Context, args *NetworkArgs) (network.Network, error) {
+		arguments := make([]reflect.Value, len(argConstructors))
+		for i, makeArg := range argConstructors {
+			arg := reflect.ValueOf(makeArg(ctx, args))
+			// nil arguments (e.g., an unset reporter) have no value.
+			if !arg.IsValid() {
+				arg = reflect.Zero(t.In(i))
+			}
+			arguments[i] = arg
+		}
+		val, err := callConstructor(v, arguments)
+		if err != nil {
+			return nil, err
+		}
+		return val.(network.Network), nil
+	}, nil
+}
+
+// makeNetwork constructs the network, defaulting to a swarm.
+func makeNetwork(ctx context.Context, netC NetworkC, args *NetworkArgs) (network.Network, error) {
+	if netC == nil {
+		swrm := swarm.NewSwarm(ctx, args.PeerID, args.Peerstore, args.Reporter)
+		swrm.Filters = args.Filters
+		return swrm, nil
+	}
+
+	n, err := netC(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if n.LocalPeer() != args.PeerID {
+		n.Close()
+		return nil, fmt.Errorf("network has peer ID %s, expected %s", n.LocalPeer(), args.PeerID)
+	}
+	return n, nil
+}
+
+// networkContext returns a context that's canceled when the network shuts down.
+func networkContext(n network.Network) context.Context {
+	if swrm, ok := n.(*swarm.Swarm); ok {
+		return swrm.Context()
+	}
+	return goprocessctx.OnClosingContext(n.Process())
+}
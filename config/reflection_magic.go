@@ -119,7 +119,12 @@ func makeConstructor(
 	return func(h host.Host, u *tptu.Upgrader) (interface{}, error) {
 		arguments := make([]reflect.Value, len(argConstructors))
 		for i, makeArg := range argConstructors {
-			arguments[i] = reflect.ValueOf(makeArg(h, u))
+			arg := reflect.ValueOf(makeArg(h, u))
+			// nil arguments (e.g., an unset reporter) have no value.
+			if !arg.IsValid() {
+				arg = reflect.Zero(t.In(i))
+			}
+			arguments[i] = arg
 		}
 		return callConstructor(v, arguments)
 	}, nil
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The component registry maps stable names (e.g. "tcp", "yamux", "secio") to
+// transport, stream muxer and security transport constructors. It allows
+// configuration files, CLIs and plugins to select components without importing
+// them directly.
+var registry = struct {
+	mx         sync.RWMutex
+	transports map[string]TptC
+	muxers     map[string]MsMuxC
+	security   map[string]MsSecC
+}{
+	transports: make(map[string]TptC),
+	muxers:     make(map[string]MsMuxC),
+	security:   make(map[string]MsSecC),
+}
+
+// RegisterTransport registers a transport (or transport constructor, see
+// TransportConstructor) under the given name.
+//
+// It returns an error if the name is already taken or the constructor is
+// invalid.
+func RegisterTransport(name string, tpt interface{}) error {
+	if name == "" {
+		return fmt.Errorf("cannot register a transport without a name")
+	}
+	tptc, err := TransportConstructor(tpt)
+	if err != nil {
+		return err
+	}
+
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if _, ok := registry.transports[name]; ok {
+		return fmt.Errorf("transport %q already registered", name)
+	}
+	registry.transports[name] = tptc
+	return nil
+}
+
+// RegisterMuxer registers a stream multiplexer (or stream multiplexer
+// constructor, see MuxerConstructor) under the given name. The protocol ID is
+// the ID the muxer is negotiated with.
+//
+// It returns an error if the name is already taken or the constructor is
+// invalid.
+func RegisterMuxer(name, id string, m interface{}) error {
+	if name == "" || id == "" {
+		return fmt.Errorf("cannot register a muxer without a name and protocol ID")
+	}
+	muxc, err := MuxerConstructor(m)
+	if err != nil {
+		return err
+	}
+
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if _, ok := registry.muxers[name]; ok {
+		return fmt.Errorf("muxer %q already registered", name)
+	}
+	registry.muxers[name] = MsMuxC{MuxC: muxc, ID: id}
+	return nil
+}
+
+// RegisterSecurity registers a security transport (or security transport
+// constructor, see SecurityConstructor) under the given name. The protocol ID
+// is the ID the security transport is negotiated with.
+//
+// It returns an error if the name is already taken or the constructor is
+// invalid.
+func RegisterSecurity(name, id string, security interface{}) error {
+	if name == "" || id == "" {
+		return fmt.Errorf("cannot register a security transport without a name and protocol ID")
+	}
+	secc, err := SecurityConstructor(security)
+	if err != nil {
+		return err
+	}
+
+	registry.mx.Lock()
+	defer registry.mx.Unlock()
+	if _, ok := registry.security[name]; ok {
+		return fmt.Errorf("security transport %q already registered", name)
+	}
+	registry.security[name] = MsSecC{SecC: secc, ID: id}
+	return nil
+}
+
+// LookupTransport returns the transport constructor registered under the
+// given name.
+func LookupTransport(name string) (TptC, error) {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	tptc, ok := registry.transports[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", name)
+	}
+	return tptc, nil
+}
+
+// LookupMuxer returns the stream multiplexer constructor registered under the
+// given name.
+func LookupMuxer(name string) (MsMuxC, error) {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	muxc, ok := registry.muxers[name]
+	if !ok {
+		return MsMuxC{}, fmt.Errorf("unknown muxer %q", name)
+	}
+	return muxc, nil
+}
+
+// LookupSecurity returns the security transport constructor registered under
+// the given name.
+func LookupSecurity(name string) (MsSecC, error) {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	secc, ok := registry.security[name]
+	if !ok {
+		return MsSecC{}, fmt.Errorf("unknown security transport %q", name)
+	}
+	return secc, nil
+}
+
+// RegisteredTransports returns the sorted names of all registered transports.
+func RegisteredTransports() []string {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	names := make([]string, 0, len(registry.transports))
+	for name := range registry.transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisteredMuxers returns the sorted names of all registered stream
+// multiplexers.
+func RegisteredMuxers() []string {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	names := make([]string, 0, len(registry.muxers))
+	for name := range registry.muxers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisteredSecurity returns the sorted names of all registered security
+// transports.
+func RegisteredSecurity() []string {
+	registry.mx.RLock()
+	defer registry.mx.RUnlock()
+	names := make([]string, 0, len(registry.security))
+	for name := range registry.security {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/sec"
+	"github.com/libp2p/go-libp2p-core/transport"
+
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	yamux "github.com/libp2p/go-libp2p-yamux"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	if err := RegisterTransport("test-tpt", func(_ *tptu.Upgrader) transport.Transport { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTransport("test-tpt", func(_ *tptu.Upgrader) transport.Transport { return nil }); err == nil {
+		t.Error("expected registering a transport twice to fail")
+	}
+	if err := RegisterTransport("test-bad-tpt", func() {}); err == nil {
+		t.Error("expected registering an invalid transport constructor to fail")
+	}
+	if _, err := LookupTransport("test-tpt"); err != nil {
+		t.Error(err)
+	}
+	if _, err := LookupTransport("test-bad-tpt"); err == nil {
+		t.Error("expected lookup of an unregistered transport to fail")
+	}
+
+	found := false
+	for _, name := range RegisteredTransports() {
+		if name == "test-tpt" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected test-tpt to be listed as a registered transport")
+	}
+}
+
+func TestRegisterMuxer(t *testing.T) {
+	if err := RegisterMuxer("test-yamux", "/test-yamux/1.0.0", yamux.DefaultTransport); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterMuxer("test-yamux", "/test-yamux/2.0.0", yamux.DefaultTransport); err == nil {
+		t.Error("expected registering a muxer twice to fail")
+	}
+	if err := RegisterMuxer("test-no-id", "", yamux.DefaultTransport); err == nil {
+		t.Error("expected registering a muxer without a protocol ID to fail")
+	}
+	if err := RegisterMuxer("test-bad-muxer", "/bad", func(string) mux.Multiplexer { return nil }); err == nil {
+		t.Error("expected registering an invalid muxer constructor to fail")
+	}
+
+	muxc, err := LookupMuxer("test-yamux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if muxc.ID != "/test-yamux/1.0.0" {
+		t.Errorf("expected protocol ID /test-yamux/1.0.0, got %s", muxc.ID)
+	}
+}
+
+func TestRegisterSecurity(t *testing.T) {
+	if err := RegisterSecurity("test-sec", "/test-sec/1.0.0", func(_ peer.ID) sec.SecureTransport { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterSecurity("test-sec", "/test-sec/1.0.0", func(_ peer.ID) sec.SecureTransport { return nil }); err == nil {
+		t.Error("expected registering a security transport twice to fail")
+	}
+	secc, err := LookupSecurity("test-sec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secc.ID != "/test-sec/1.0.0" {
+		t.Errorf("expected protocol ID /test-sec/1.0.0, got %s", secc.ID)
+	}
+	if _, err := LookupSecurity("test-unknown"); err == nil {
+		t.Error("expected lookup of an unregistered security transport to fail")
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/libp2p/go-libp2p-core/host"
+
+	"github.com/jbenet/goprocess"
+)
+
+// ServiceC is the type for user service constructors. You probably won't ever
+// implement this function interface directly. Instead, pass your service
+// constructor to ServiceConstructor.
+type ServiceC func(h host.Host) (io.Closer, error)
+
+var closerType = reflect.TypeOf((*io.Closer)(nil)).Elem()
+
+var serviceArgTypes = newArgTypeSet(
+	hostType, networkType, peerIDType,
+	privKeyType, pubKeyType, pstoreType,
+	eventBusType,
+)
+
+// ServiceConstructor uses reflection to turn a function that constructs a
+// service into a ServiceC.
+//
+// The constructor can take any of:
+//
+// * The local peer ID.
+// * A private key.
+// * A public key.
+// * A Host.
+// * A Network.
+// * A Peerstore.
+// * An event bus.
+//
+// And returns a type implementing io.Closer and, optionally, an error (as the
+// second argument).
+func ServiceConstructor(svc interface{}) (ServiceC, error) {
+	ctor, err := makeConstructor(svc, closerType, serviceArgTypes)
+	if err != nil {
+		return nil, err
+	}
+	return func(h host.Host) (io.Closer, error) {
+		s, err := ctor(h, nil)
+		if err != nil {
+			return nil, err
+		}
+		return s.(io.Closer), nil
+	}, nil
+}
+
+// startServices constructs the services in order and ties their lifetime to
+// the given process. The services are closed in reverse order when the process
+// closes, before the process' own teardown runs.
+//
+// If a service fails to construct, the services constructed so far are closed
+// and the error is returned.
+func startServices(proc goprocess.Process, h host.Host, svcs []ServiceC) error {
+	if len(svcs) == 0 {
+		return nil
+	}
+
+	services := make([]io.Closer, 0, len(svcs))
+	for _, sC := range svcs {
+		s, err := sC(h)
+		if err != nil {
+			closeServices(services)
+			return err
+		}
+		services = append(services, s)
+	}
+
+	proc.AddChild(goprocess.WithTeardown(func() error {
+		closeServices(services)
+		return nil
+	}))
+	return nil
+}
+
+func closeServices(services []io.Closer) {
+	for i := len(services) - 1; i >= 0; i-- {
+		if err := services[i].Close(); err != nil {
+			log.Warningf("error closing service %T: %s", services[i], err)
+		}
+	}
+}
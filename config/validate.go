@@ -0,0 +1,323 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/pnet"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/transport"
+
+	"github.com/jbenet/goprocess"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ValidationError is returned by Config.Validate and lists every problem found
+// in the config.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid libp2p config: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the config for errors without constructing a node.
+//
+// In addition to checking for incompatible options, it resolves the security
+// transport, muxer and transport constructors and checks that every listen
+// address can be handled by one of the configured transports (unless a custom
+// network is configured). The constructors are called with a host that has no
+// usable network (see checkComponents), so Validate doesn't open listeners or
+// modify the configured peerstore.
+//
+// If the config is invalid, Validate returns a *ValidationError.
+func (cfg *Config) Validate() error {
+	errs := cfg.checkOptions()
+	if cfg.PeerKey != nil {
+		errs = append(errs, cfg.checkComponents()...)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// checkOptions checks for missing and incompatible options. It doesn't
+// construct anything and is cheap enough to run before starting a node.
+func (cfg *Config) checkOptions() []error {
+	var errs []error
+
+	if pnet.ForcePrivateNetwork && len(cfg.PSK) == 0 {
+		// Note: This is *also* checked the upgrader itself so it'll be
+		// enforced even *if* you don't use the libp2p constructor.
+		errs = append(errs, pnet.ErrNotInPrivateNetwork)
+	}
+
+	if cfg.PeerKey == nil {
+		errs = append(errs, fmt.Errorf("no peer key specified"))
+	}
+
+	if cfg.Peerstore == nil {
+		errs = append(errs, fmt.Errorf("no peerstore specified"))
+	}
+
+	if cfg.Insecure && len(cfg.SecurityTransports) > 0 {
+		errs = append(errs, fmt.Errorf("cannot use security transports with an insecure libp2p configuration"))
+	}
+
+	if cfg.EnableAutoRelay {
+		if !cfg.Relay {
+			errs = append(errs, fmt.Errorf("cannot enable autorelay; relay is not enabled"))
+		} else if cfg.Routing == nil && (isRelayHop(cfg.RelayOpts) || len(cfg.StaticRelays) == 0) {
+			errs = append(errs, fmt.Errorf("cannot enable autorelay; no routing for discovery"))
+		}
+	}
+
+	// The swarm dials all the addresses of a peer itself.
+	if cfg.DialRanker != nil && cfg.Network == nil {
+		errs = append(errs, fmt.Errorf("cannot rank dials; the swarm can't dial specific addresses"))
+	}
+
+	return errs
+}
+
+// checkComponents constructs the configured security transports, muxers and
+// transports and checks the listen addresses against the transports.
+//
+// The components are constructed against a dryRunHost, with a throwaway
+// peerstore and a network that can't be used, and are never added to a
+// network, so they don't listen or dial. Constructors using the network or
+// other parts of a running host at construction can't be checked this way;
+// they're skipped, as well as the listen addresses no checked transport
+// handles.
+func (cfg *Config) checkComponents() []error {
+	pid, err := peer.IDFromPublicKey(cfg.PeerKey.GetPublic())
+	if err != nil {
+		return []error{err}
+	}
+
+	// Use a separate peerstore so we don't touch the configured one.
+	ps := pstoremem.NewPeerstore()
+	defer ps.Close()
+	if err := ps.AddPrivKey(pid, cfg.PeerKey); err != nil {
+		return []error{err}
+	}
+	if err := ps.AddPubKey(pid, cfg.PeerKey.GetPublic()); err != nil {
+		return []error{err}
+	}
+	h := &dryRunHost{id: pid, ps: ps, bus: eventbus.NewBus()}
+
+	upgrader := new(tptu.Upgrader)
+	upgrader.PSK = cfg.PSK
+	upgrader.Filters = cfg.Filters
+
+	var errs []error
+	if cfg.Insecure {
+		upgrader.Secure = makeInsecureTransport(pid, cfg.PeerKey)
+	} else {
+		err := dryRun(func() (err error) {
+			upgrader.Secure, err = makeSecurityTransport(h, cfg.SecurityTransports)
+			return err
+		})
+		if err == errNeedsHost {
+			log.Debugf("security transports need a running host, not checking them")
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err = dryRun(func() (err error) {
+		upgrader.Muxer, err = makeMuxer(h, cfg.Muxers)
+		return err
+	})
+	if err == errNeedsHost {
+		log.Debugf("muxers need a running host, not checking them")
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+
+	// We can't tell which addresses a custom network can listen on without
+	// constructing it.
+	if cfg.Network != nil {
+		return errs
+	}
+
+	// transports by the protocols they handle, like the swarm registers them.
+	tpts := make(map[int]transport.Transport)
+	skipped := false
+	for i, tptC := range cfg.Transports {
+		var t transport.Transport
+		err := dryRun(func() (err error) {
+			t, err = tptC(h, upgrader)
+			return err
+		})
+		if err == errNeedsHost {
+			log.Debugf("transport %d needs a running host, not checking it", i)
+			skipped = true
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transport %d: %s", i, err))
+			continue
+		}
+		for _, p := range t.Protocols() {
+			tpts[p] = t
+		}
+	}
+
+	for _, addr := range cfg.ListenAddrs {
+		if isRelayAddr(addr) {
+			if !cfg.Relay {
+				errs = append(errs, fmt.Errorf("cannot listen on %s; relay is not enabled", addr))
+			}
+			continue
+		}
+		if transportForListening(tpts, addr) != nil {
+			continue
+		}
+		if skipped {
+			log.Debugf("not checking listen address %s, it may be handled by a transport that wasn't checked", addr)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("no transport for listen address %s", addr))
+	}
+
+	return errs
+}
+
+// errNeedsHost is what the dryRunHost panics with when a constructor uses what
+// it doesn't provide.
+var errNeedsHost = errors.New("constructor needs a running host")
+
+// dryRun calls f, returning errNeedsHost if f uses what the dryRunHost doesn't
+// provide. Other panics are propagated.
+func dryRun(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errNeedsHost {
+				panic(r)
+			}
+			err = errNeedsHost
+		}
+	}()
+	return f()
+}
+
+// transportForListening returns the transport the swarm listens on the address
+// with: the transport of the address' last protocol, unless a proxy transport
+// handles one of the protocols.
+func transportForListening(tpts map[int]transport.Transport, addr ma.Multiaddr) transport.Transport {
+	protos := addr.Protocols()
+	if len(protos) == 0 {
+		return nil
+	}
+	selected := tpts[protos[len(protos)-1].Code]
+	for _, p := range protos {
+		if t, ok := tpts[p.Code]; ok && t.Proxy() {
+			selected = t
+		}
+	}
+	return selected
+}
+
+// dryRunHost is the host constructors are called with by Validate. It only
+// has an identity, a peerstore, an event bus and a dryRunNetwork; the methods
+// needing a running host panic with errNeedsHost.
+type dryRunHost struct {
+	id  peer.ID
+	ps  peerstore.Peerstore
+	bus event.Bus
+}
+
+var _ host.Host = (*dryRunHost)(nil)
+
+func (h *dryRunHost) ID() peer.ID                         { return h.id }
+func (h *dryRunHost) Peerstore() peerstore.Peerstore      { return h.ps }
+func (h *dryRunHost) EventBus() event.Bus                 { return h.bus }
+func (h *dryRunHost) Network() network.Network            { return (*dryRunNetwork)(h) }
+func (h *dryRunHost) Addrs() []ma.Multiaddr               { return nil }
+func (h *dryRunHost) ConnManager() connmgr.ConnManager    { return connmgr.NullConnMgr{} }
+func (h *dryRunHost) Close() error                        { return nil }
+func (h *dryRunHost) Mux() protocol.Switch                { panic(errNeedsHost) }
+func (h *dryRunHost) RemoveStreamHandler(pid protocol.ID) { panic(errNeedsHost) }
+
+func (h *dryRunHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	panic(errNeedsHost)
+}
+
+func (h *dryRunHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	panic(errNeedsHost)
+}
+
+func (h *dryRunHost) SetStreamHandlerMatch(pid protocol.ID, m func(string) bool, handler network.StreamHandler) {
+	panic(errNeedsHost)
+}
+
+func (h *dryRunHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	panic(errNeedsHost)
+}
+
+// dryRunNetwork is the network of the dryRunHost. Constructors may keep it,
+// but using it panics with errNeedsHost.
+type dryRunNetwork dryRunHost
+
+var _ network.Network = (*dryRunNetwork)(nil)
+
+func (n *dryRunNetwork) Peerstore() peerstore.Peerstore { return n.ps }
+func (n *dryRunNetwork) LocalPeer() peer.ID             { return n.id }
+func (n *dryRunNetwork) Close() error                   { return nil }
+func (n *dryRunNetwork) Notify(network.Notifiee)        {}
+func (n *dryRunNetwork) StopNotify(network.Notifiee)    {}
+
+func (n *dryRunNetwork) DialPeer(context.Context, peer.ID) (network.Conn, error) {
+	panic(errNeedsHost)
+}
+
+func (n *dryRunNetwork) ClosePeer(peer.ID) error                           { panic(errNeedsHost) }
+func (n *dryRunNetwork) Connectedness(peer.ID) network.Connectedness       { panic(errNeedsHost) }
+func (n *dryRunNetwork) Peers() []peer.ID                                  { panic(errNeedsHost) }
+func (n *dryRunNetwork) Conns() []network.Conn                             { panic(errNeedsHost) }
+func (n *dryRunNetwork) ConnsToPeer(peer.ID) []network.Conn                { panic(errNeedsHost) }
+func (n *dryRunNetwork) SetStreamHandler(network.StreamHandler)            { panic(errNeedsHost) }
+func (n *dryRunNetwork) SetConnHandler(network.ConnHandler)                { panic(errNeedsHost) }
+func (n *dryRunNetwork) Listen(...ma.Multiaddr) error                      { panic(errNeedsHost) }
+func (n *dryRunNetwork) ListenAddresses() []ma.Multiaddr                   { panic(errNeedsHost) }
+func (n *dryRunNetwork) InterfaceListenAddresses() ([]ma.Multiaddr, error) { panic(errNeedsHost) }
+func (n *dryRunNetwork) Process() goprocess.Process                        { panic(errNeedsHost) }
+
+func (n *dryRunNetwork) NewStream(context.Context, peer.ID) (network.Stream, error) {
+	panic(errNeedsHost)
+}
+
+func isRelayHop(opts []circuit.RelayOpt) bool {
+	for _, opt := range opts {
+		if opt == circuit.OptHop {
+			return true
+		}
+	}
+	return false
+}
+
+func isRelayAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
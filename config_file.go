@@ -0,0 +1,541 @@
+package libp2p
+
+// This file contains the declarative (file based) libp2p configuration.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	config "github.com/libp2p/go-libp2p/config"
+	ma "github.com/multiformats/go-multiaddr"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultConnMgrGracePeriod is the grace period used by the connection manager
+// when the configuration file doesn't specify one.
+const defaultConnMgrGracePeriod = 20 * time.Second
+
+// FileConfig is the serializable form of a libp2p node configuration. It can be
+// loaded from a JSON or YAML document and translated into the equivalent set of
+// libp2p options with Options.
+//
+// Fields left unset fall back on the usual defaults when the resulting options
+// are passed to New.
+type FileConfig struct {
+	// ListenAddrs are the multiaddrs to listen on.
+	ListenAddrs []string `json:"listenAddrs,omitempty" yaml:"listenAddrs,omitempty"`
+	// NoListenAddrs disables listening entirely (see NoListenAddrs).
+	NoListenAddrs bool `json:"noListenAddrs,omitempty" yaml:"noListenAddrs,omitempty"`
+	// ListenStrict fails node construction if any listen address can't be
+	// bound (see ListenStrict).
+	ListenStrict bool `json:"listenStrict,omitempty" yaml:"listenStrict,omitempty"`
+
+	// Identity configures the private key of the node.
+	Identity *IdentityFileConfig `json:"identity,omitempty" yaml:"identity,omitempty"`
+
+	// Transports, Muxers and Security select components by the name they
+	// were registered under (see config.RegisterTransport). An explicitly
+	// empty transport list disables all transports.
+	Transports []string `json:"transports,omitempty" yaml:"transports,omitempty"`
+	Muxers     []string `json:"muxers,omitempty" yaml:"muxers,omitempty"`
+	Security   []string `json:"security,omitempty" yaml:"security,omitempty"`
+	// Insecure disables transport security (see NoSecurity).
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+
+	Relay     *RelayFileConfig     `json:"relay,omitempty" yaml:"relay,omitempty"`
+	AutoRelay *AutoRelayFileConfig `json:"autoRelay,omitempty" yaml:"autoRelay,omitempty"`
+
+	// Filters is a list of CIDR ranges we should never dial nor accept
+	// connections from.
+	Filters []string `json:"filters,omitempty" yaml:"filters,omitempty"`
+
+	// Ping enables or disables the ping service (default: enabled).
+	Ping *bool `json:"ping,omitempty" yaml:"ping,omitempty"`
+	// UserAgent is sent to other peers via the identify protocol.
+	UserAgent string `json:"userAgent,omitempty" yaml:"userAgent,omitempty"`
+	// NATPortMap enables NAT port mapping (see NATPortMap).
+	NATPortMap bool `json:"natPortMap,omitempty" yaml:"natPortMap,omitempty"`
+
+	ConnManager *ConnManagerFileConfig `json:"connManager,omitempty" yaml:"connManager,omitempty"`
+}
+
+// IdentityFileConfig configures the identity of a node loaded from a
+// configuration file.
+type IdentityFileConfig struct {
+	// KeyFile is the path to a file containing a marshalled private key
+	// (see crypto.MarshalPrivateKey and IdentityFromFile).
+	KeyFile string `json:"keyFile" yaml:"keyFile"`
+
+	// Create generates a new key and writes it to KeyFile if it doesn't
+	// exist.
+	Create bool `json:"create,omitempty" yaml:"create,omitempty"`
+
+	// KeyType is the type of key to generate: "ed25519" (the default),
+	// "rsa", "secp256k1" or "ecdsa".
+	KeyType string `json:"keyType,omitempty" yaml:"keyType,omitempty"`
+
+	// PassphraseEnv is the name of the environment variable holding the
+	// passphrase the key file is encrypted with (see KeyPassphrase). Keys
+	// are created encrypted if it's set.
+	PassphraseEnv string `json:"passphraseEnv,omitempty" yaml:"passphraseEnv,omitempty"`
+}
+
+// RelayFileConfig configures the relay transport.
+type RelayFileConfig struct {
+	// Enabled enables or disables the relay transport (default: enabled).
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Hop, Active and Discovery map to circuit.OptHop, circuit.OptActive
+	// and circuit.OptDiscovery.
+	Hop       bool `json:"hop,omitempty" yaml:"hop,omitempty"`
+	Active    bool `json:"active,omitempty" yaml:"active,omitempty"`
+	Discovery bool `json:"discovery,omitempty" yaml:"discovery,omitempty"`
+}
+
+// AutoRelayFileConfig configures the AutoRelay subsystem.
+type AutoRelayFileConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// StaticRelays are the p2p multiaddrs of known relays.
+	StaticRelays []string `json:"staticRelays,omitempty" yaml:"staticRelays,omitempty"`
+	// DefaultStaticRelays adds the known PL-operated relays.
+	DefaultStaticRelays bool `json:"defaultStaticRelays,omitempty" yaml:"defaultStaticRelays,omitempty"`
+}
+
+// ConnManagerFileConfig configures the connection manager limits.
+type ConnManagerFileConfig struct {
+	LowWater  int `json:"lowWater" yaml:"lowWater"`
+	HighWater int `json:"highWater" yaml:"highWater"`
+	// GracePeriod is a duration string (e.g. "20s"). Defaults to 20s.
+	GracePeriod string `json:"gracePeriod,omitempty" yaml:"gracePeriod,omitempty"`
+}
+
+// FieldError is returned when a configuration file contains an invalid value.
+// Field is the path to the offending field, e.g. "listenAddrs[1]".
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func fieldErrorf(field string, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// FromConfigFile loads the configuration file at the given path and returns
+// the equivalent libp2p options. Files ending in ".json" are decoded as JSON,
+// files ending in ".yaml" or ".yml" are decoded as YAML.
+func FromConfigFile(path string) ([]Option, error) {
+	fc, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := fc.Options()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return opts, nil
+}
+
+// LoadConfigFile reads and decodes the configuration file at the given path
+// without translating it into options.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc *FileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		fc, err = ParseJSONConfig(data)
+	case ".yaml", ".yml":
+		fc, err = ParseYAMLConfig(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown configuration file format, expected .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return fc, nil
+}
+
+// ParseJSONConfig decodes a JSON configuration document. Unknown fields are
+// rejected.
+func ParseJSONConfig(data []byte) (*FileConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var fc FileConfig
+	if err := dec.Decode(&fc); err != nil {
+		if terr, ok := err.(*json.UnmarshalTypeError); ok && terr.Field != "" {
+			return nil, fieldErrorf(terr.Field, "expected %s, got %s", terr.Type, terr.Value)
+		}
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the configuration object")
+	}
+	return &fc, nil
+}
+
+// ParseYAMLConfig decodes a YAML configuration document. Unknown fields are
+// rejected.
+//
+// The document is converted to JSON and decoded like a JSON document, so
+// that values of the wrong type are reported as a *FieldError with the path
+// of the field; the YAML decoder only reports the line.
+func ParseYAMLConfig(data []byte) (*FileConfig, error) {
+	var doc interface{}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, err
+	}
+	v, err := yamlToJSON(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONConfig(js)
+}
+
+// yamlToJSON converts a decoded YAML value into one encoding/json can
+// marshal: YAML mappings are decoded with interface{} keys, JSON objects
+// only have string keys.
+func yamlToJSON(v interface{}, field string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			key, ok := k.(string)
+			if !ok && field == "" {
+				return nil, fmt.Errorf("expected a string key, got %v", k)
+			} else if !ok {
+				return nil, fieldErrorf(field, "expected a string key, got %v", k)
+			}
+			f := key
+			if field != "" {
+				f = field + "." + key
+			}
+			var err error
+			if m[key], err = yamlToJSON(e, f); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if l[i], err = yamlToJSON(e, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	default:
+		return v, nil
+	}
+}
+
+// Options validates the configuration and translates it into libp2p options.
+// Invalid values are reported as a *FieldError.
+func (fc *FileConfig) Options() ([]Option, error) {
+	var opts []Option
+
+	// Listen addresses.
+	if fc.NoListenAddrs && len(fc.ListenAddrs) > 0 {
+		return nil, fieldErrorf("noListenAddrs", "cannot be combined with listenAddrs")
+	}
+	if fc.NoListenAddrs {
+		opts = append(opts, NoListenAddrs)
+	}
+	if len(fc.ListenAddrs) > 0 {
+		addrs := make([]ma.Multiaddr, len(fc.ListenAddrs))
+		for i, s := range fc.ListenAddrs {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				return nil, fieldErrorf(fmt.Sprintf("listenAddrs[%d]", i), "invalid multiaddr %q: %s", s, err)
+			}
+			addrs[i] = a
+		}
+		opts = append(opts, ListenAddrs(addrs...))
+	}
+	if fc.ListenStrict {
+		opts = append(opts, ListenStrict())
+	}
+
+	// Identity.
+	if fc.Identity != nil {
+		opt, err := fc.Identity.option()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	// Transports, muxers and security.
+	if fc.Transports != nil && len(fc.Transports) == 0 {
+		opts = append(opts, NoTransports)
+	}
+	tpts, err := namedOptions("transports", "transport", fc.Transports, transportByName)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, tpts...)
+
+	if fc.Muxers != nil && len(fc.Muxers) == 0 {
+		return nil, fieldErrorf("muxers", "must not be empty")
+	}
+	muxers, err := namedOptions("muxers", "muxer", fc.Muxers, muxerByName)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, muxers...)
+
+	if fc.Insecure {
+		if len(fc.Security) > 0 {
+			return nil, fieldErrorf("insecure", "cannot be combined with security transports")
+		}
+		opts = append(opts, NoSecurity)
+	} else if fc.Security != nil && len(fc.Security) == 0 {
+		return nil, fieldErrorf("security", "must not be empty, set insecure to disable transport security")
+	}
+	secs, err := namedOptions("security", "security transport", fc.Security, securityByName)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, secs...)
+
+	// Relay and autorelay.
+	relayEnabled := true
+	if fc.Relay != nil {
+		opt, err := fc.Relay.option()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		relayEnabled = fc.Relay.Enabled == nil || *fc.Relay.Enabled
+	} else if fc.NoListenAddrs {
+		// NoListenAddrs disables the relay unless configured explicitly.
+		relayEnabled = false
+	}
+	if fc.AutoRelay != nil {
+		arOpts, err := fc.AutoRelay.options(relayEnabled)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, arOpts...)
+	}
+
+	// Address filters.
+	if len(fc.Filters) > 0 {
+		nets := make([]*net.IPNet, len(fc.Filters))
+		for i, s := range fc.Filters {
+			_, ipnet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fieldErrorf(fmt.Sprintf("filters[%d]", i), "invalid CIDR %q", s)
+			}
+			nets[i] = ipnet
+		}
+		opts = append(opts, FilterAddresses(nets...))
+	}
+
+	// Services.
+	if fc.Ping != nil {
+		opts = append(opts, Ping(*fc.Ping))
+	}
+	if fc.UserAgent != "" {
+		opts = append(opts, UserAgent(fc.UserAgent))
+	}
+	if fc.NATPortMap {
+		opts = append(opts, NATPortMap())
+	}
+	if fc.ConnManager != nil {
+		opt, err := fc.ConnManager.option()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	return opts, nil
+}
+
+// namedOptions resolves a list of component names through the component
+// registry, rejecting unknown and duplicate names.
+func namedOptions(field, kind string, names []string, lookup func(string) (Option, error)) ([]Option, error) {
+	opts := make([]Option, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for i, name := range names {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if _, ok := seen[name]; ok {
+			return nil, fieldErrorf(f, "duplicate %s %q", kind, name)
+		}
+		seen[name] = struct{}{}
+
+		opt, err := lookup(name)
+		if err != nil {
+			return nil, &FieldError{Field: f, Err: err}
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+func transportByName(name string) (Option, error) {
+	if _, err := config.LookupTransport(name); err != nil {
+		return nil, err
+	}
+	return TransportByName(name), nil
+}
+
+func muxerByName(name string) (Option, error) {
+	if _, err := config.LookupMuxer(name); err != nil {
+		return nil, err
+	}
+	return MuxerByName(name), nil
+}
+
+func securityByName(name string) (Option, error) {
+	if _, err := config.LookupSecurity(name); err != nil {
+		return nil, err
+	}
+	return SecurityByName(name), nil
+}
+
+func (ic *IdentityFileConfig) option() (Option, error) {
+	if ic.KeyFile == "" {
+		return nil, fieldErrorf("identity.keyFile", "must be set")
+	}
+	keyType := DefaultKeyType
+	if ic.KeyType != "" {
+		var ok bool
+		keyType, ok = keyTypesByName[strings.ToLower(ic.KeyType)]
+		if !ok {
+			return nil, fieldErrorf("identity.keyType", "unknown key type %q", ic.KeyType)
+		}
+	}
+
+	var passphrase []byte
+	if ic.PassphraseEnv != "" {
+		passphrase = []byte(os.Getenv(ic.PassphraseEnv))
+		if len(passphrase) == 0 {
+			return nil, fieldErrorf("identity.passphraseEnv", "environment variable %s is not set", ic.PassphraseEnv)
+		}
+	}
+
+	sk, err := loadKeyFile(ic.KeyFile, passphrase)
+	if os.IsNotExist(err) && ic.Create {
+		opts := []KeyFileOption{KeyType(keyType, 0)}
+		if passphrase != nil {
+			opts = append(opts, KeyPassphrase(passphrase))
+		}
+		return IdentityFromFile(ic.KeyFile, true, opts...), nil
+	}
+	if err != nil {
+		return nil, &FieldError{Field: "identity.keyFile", Err: err}
+	}
+	return Identity(sk), nil
+}
+
+var keyTypesByName = map[string]int{
+	"rsa":       crypto.RSA,
+	"ed25519":   crypto.Ed25519,
+	"secp256k1": crypto.Secp256k1,
+	"ecdsa":     crypto.ECDSA,
+}
+
+func (rc *RelayFileConfig) option() (Option, error) {
+	if rc.Enabled != nil && !*rc.Enabled {
+		if rc.Hop || rc.Active || rc.Discovery {
+			return nil, fieldErrorf("relay.enabled", "relay options set but the relay is disabled")
+		}
+		return DisableRelay(), nil
+	}
+
+	var relayOpts []circuit.RelayOpt
+	if rc.Active {
+		relayOpts = append(relayOpts, circuit.OptActive)
+	}
+	if rc.Hop {
+		relayOpts = append(relayOpts, circuit.OptHop)
+	}
+	if rc.Discovery {
+		relayOpts = append(relayOpts, circuit.OptDiscovery)
+	}
+	return EnableRelay(relayOpts...), nil
+}
+
+func (ac *AutoRelayFileConfig) options(relayEnabled bool) ([]Option, error) {
+	if !ac.Enabled {
+		if len(ac.StaticRelays) > 0 || ac.DefaultStaticRelays {
+			return nil, fieldErrorf("autoRelay.enabled", "static relays configured but autorelay is disabled")
+		}
+		return nil, nil
+	}
+	if !relayEnabled {
+		return nil, fieldErrorf("autoRelay.enabled", "cannot enable autorelay; relay is not enabled")
+	}
+
+	opts := []Option{EnableAutoRelay()}
+	if len(ac.StaticRelays) > 0 {
+		relays := make([]peer.AddrInfo, len(ac.StaticRelays))
+		for i, s := range ac.StaticRelays {
+			f := fmt.Sprintf("autoRelay.staticRelays[%d]", i)
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				return nil, fieldErrorf(f, "invalid multiaddr %q: %s", s, err)
+			}
+			pi, err := peer.AddrInfoFromP2pAddr(a)
+			if err != nil {
+				return nil, fieldErrorf(f, "invalid relay address %q: %s", s, err)
+			}
+			relays[i] = *pi
+		}
+		opts = append(opts, StaticRelays(relays))
+	}
+	if ac.DefaultStaticRelays {
+		opts = append(opts, DefaultStaticRelays())
+	}
+	return opts, nil
+}
+
+func (cc *ConnManagerFileConfig) option() (Option, error) {
+	if cc.LowWater < 0 {
+		return nil, fieldErrorf("connManager.lowWater", "must not be negative")
+	}
+	if cc.HighWater <= 0 {
+		return nil, fieldErrorf("connManager.highWater", "must be positive")
+	}
+	if cc.LowWater > cc.HighWater {
+		return nil, fieldErrorf("connManager.lowWater", "must not exceed highWater (%d)", cc.HighWater)
+	}
+
+	grace := defaultConnMgrGracePeriod
+	if cc.GracePeriod != "" {
+		d, err := time.ParseDuration(cc.GracePeriod)
+		if err != nil {
+			return nil, fieldErrorf("connManager.gracePeriod", "invalid duration %q", cc.GracePeriod)
+		}
+		if d < 0 {
+			return nil, fieldErrorf("connManager.gracePeriod", "must not be negative")
+		}
+		grace = d
+	}
+	return ConnectionManager(connmgr.NewConnManager(cc.LowWater, cc.HighWater, grace)), nil
+}
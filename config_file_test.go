@@ -0,0 +1,239 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func writeTempFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFromConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeTempFile(t, dir, "key", kb)
+	expectedID, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yml := `
+listenAddrs:
+  - /ip4/127.0.0.1/tcp/0
+identity:
+  keyFile: ` + keyFile + `
+transports: [tcp]
+muxers: [yamux, mplex]
+security: [secio]
+relay:
+  enabled: false
+filters:
+  - 10.0.0.0/8
+ping: false
+userAgent: test-agent
+connManager:
+  lowWater: 10
+  highWater: 20
+  gracePeriod: 1m
+`
+	for _, path := range []string{
+		writeTempFile(t, dir, "node.yaml", []byte(yml)),
+		writeTempFile(t, dir, "node.json", []byte(`{
+			"listenAddrs": ["/ip4/127.0.0.1/tcp/0"],
+			"identity": {"keyFile": "`+keyFile+`"},
+			"transports": ["tcp"],
+			"muxers": ["yamux", "mplex"],
+			"security": ["secio"],
+			"relay": {"enabled": false},
+			"filters": ["10.0.0.0/8"],
+			"ping": false,
+			"userAgent": "test-agent",
+			"connManager": {"lowWater": 10, "highWater": 20, "gracePeriod": "1m"}
+		}`)),
+	} {
+		opts, err := FromConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := New(context.Background(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.ID() != expectedID {
+			t.Errorf("expected peer ID %s, got %s", expectedID, h.ID())
+		}
+		if len(h.Addrs()) != 1 {
+			t.Errorf("expected exactly one listen address, got %s", h.Addrs())
+		}
+		h.Close()
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		field  string
+	}{
+		{`{"listenAddrs": ["/ip4/127.0.0.1/tcp/0", "/ip4/foo"]}`, "listenAddrs[1]"},
+		{`{"transports": ["tcp", "quic"]}`, "transports[1]"},
+		{`{"muxers": ["yamux", "yamux"]}`, "muxers[1]"},
+		{`{"muxers": []}`, "muxers"},
+		{`{"insecure": true, "security": ["secio"]}`, "insecure"},
+		{`{"filters": ["10.0.0.0"]}`, "filters[0]"},
+		{`{"relay": {"enabled": false}, "autoRelay": {"enabled": true}}`, "autoRelay.enabled"},
+		{`{"autoRelay": {"enabled": true, "staticRelays": ["/ip4/1.2.3.4/tcp/1"]}}`, "autoRelay.staticRelays[0]"},
+		{`{"connManager": {"lowWater": 30, "highWater": 20}}`, "connManager.lowWater"},
+		{`{"connManager": {"highWater": 20, "gracePeriod": "soon"}}`, "connManager.gracePeriod"},
+		{`{"identity": {}}`, "identity.keyFile"},
+		{`{"ping": "yes"}`, "ping"},
+	} {
+		fc, err := ParseJSONConfig([]byte(tc.config))
+		if err == nil {
+			_, err = fc.Options()
+		}
+		ferr, ok := err.(*FieldError)
+		if !ok {
+			t.Errorf("%s: expected a field error, got %v", tc.config, err)
+			continue
+		}
+		if ferr.Field != tc.field {
+			t.Errorf("%s: expected error for field %s, got %s", tc.config, tc.field, ferr)
+		}
+	}
+}
+
+func TestYAMLConfigFieldErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		field  string
+	}{
+		{"ping: \"yes\"\n", "ping"},
+		{"connManager:\n  lowWater: few\n  highWater: 20\n", "connManager.lowWater"},
+		{"relay:\n  hop: 1\n", "relay.hop"},
+	} {
+		_, err := ParseYAMLConfig([]byte(tc.config))
+		ferr, ok := err.(*FieldError)
+		if !ok {
+			t.Errorf("%q: expected a field error, got %v", tc.config, err)
+			continue
+		}
+		if ferr.Field != tc.field {
+			t.Errorf("%q: expected error for field %s, got %s", tc.config, tc.field, ferr)
+		}
+	}
+}
+
+func TestConfigFileUnknownField(t *testing.T) {
+	if _, err := ParseJSONConfig([]byte(`{"listenAddr": []}`)); err == nil {
+		t.Error("expected unknown JSON field to be rejected")
+	}
+	_, err := ParseYAMLConfig([]byte("listenAddr: []\n"))
+	if err == nil {
+		t.Fatal("expected unknown YAML field to be rejected")
+	}
+	if !strings.Contains(err.Error(), "listenAddr") {
+		t.Errorf("expected error to name the unknown field, got: %s", err)
+	}
+}
+
+func TestConfigFileCreateIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	fc, err := ParseJSONConfig([]byte(`{"identity": {"keyFile": "` + keyFile + `", "create": true, "keyType": "secp256k1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := fc.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := cfg.Apply(opts...); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PeerKey == nil || cfg.PeerKey.Type() != crypto.Secp256k1 {
+		t.Fatal("expected a secp256k1 key to be generated")
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err = ParseJSONConfig([]byte(`{"identity": {"keyFile": "` + keyFile + `", "keyType": "dsa"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.Options(); err == nil {
+		t.Error("expected an unknown key type to fail")
+	}
+}
+
+func TestConfigFileEncryptedIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const env = "LIBP2P_TEST_KEY_PASSPHRASE"
+	os.Setenv(env, "secret")
+	defer os.Unsetenv(env)
+
+	// a key file encrypted by IdentityFromFile.
+	keyFile := filepath.Join(dir, "key")
+	var cfg Config
+	if err := cfg.Apply(IdentityFromFile(keyFile, true, KeyPassphrase([]byte("secret")))); err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err := ParseJSONConfig([]byte(`{"identity": {"keyFile": "` + keyFile + `", "passphraseEnv": "` + env + `"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := fc.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Config
+	if err := loaded.Apply(opts...); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PeerKey == nil || !loaded.PeerKey.Equals(cfg.PeerKey) {
+		t.Fatal("expected the encrypted key to be loaded")
+	}
+
+	os.Unsetenv(env)
+	_, err = fc.Options()
+	if ferr, ok := err.(*FieldError); !ok || ferr.Field != "identity.passphraseEnv" {
+		t.Errorf("expected an error for the unset passphrase, got %v", err)
+	}
+}
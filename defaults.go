@@ -4,6 +4,7 @@ package libp2p
 
 import (
 	"crypto/rand"
+	"fmt"
 
 	crypto "github.com/libp2p/go-libp2p-core/crypto"
 	mplex "github.com/libp2p/go-libp2p-mplex"
@@ -13,8 +14,26 @@ import (
 	tcp "github.com/libp2p/go-tcp-transport"
 	ws "github.com/libp2p/go-ws-transport"
 	multiaddr "github.com/multiformats/go-multiaddr"
+
+	config "github.com/libp2p/go-libp2p/config"
 )
 
+// Register the default components so they can be selected by name (see
+// TransportByName, MuxerByName and SecurityByName).
+func init() {
+	for _, err := range []error{
+		config.RegisterTransport("tcp", tcp.NewTCPTransport),
+		config.RegisterTransport("ws", ws.New),
+		config.RegisterMuxer("yamux", "/yamux/1.0.0", yamux.DefaultTransport),
+		config.RegisterMuxer("mplex", "/mplex/6.7.0", mplex.DefaultTransport),
+		config.RegisterSecurity("secio", secio.ID, secio.New),
+	} {
+		if err != nil {
+			panic(fmt.Sprintf("failed to register default component: %s", err))
+		}
+	}
+}
+
 // DefaultSecurity is the default security option.
 //
 // Useful when you want to extend, but not replace, the supported transport
@@ -117,7 +136,7 @@ var defaults = []struct {
 // other options to *extend* the default options.
 var Defaults Option = func(cfg *Config) error {
 	for _, def := range defaults {
-		if err := cfg.Apply(def.opt); err != nil {
+		if err := cfg.ApplyDefaults(def.opt); err != nil {
 			return err
 		}
 	}
@@ -132,7 +151,7 @@ var FallbackDefaults Option = func(cfg *Config) error {
 		if !def.fallback(cfg) {
 			continue
 		}
-		if err := cfg.Apply(def.opt); err != nil {
+		if err := cfg.ApplyDefaults(def.opt); err != nil {
 			return err
 		}
 	}
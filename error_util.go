@@ -3,15 +3,41 @@ package libp2p
 import (
 	"fmt"
 	"runtime"
+
+	config "github.com/libp2p/go-libp2p/config"
 )
 
 func traceError(err error, skip int) error {
 	if err == nil {
 		return nil
 	}
+	site := callSite(skip + 1)
+	if site == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", site, err)
+}
+
+// callSite returns the file:line of the caller skip frames above the caller of
+// callSite, or an empty string if it can't be determined.
+func callSite(skip int) string {
 	_, file, line, ok := runtime.Caller(skip + 1)
 	if !ok {
-		return err
+		return ""
 	}
-	return fmt.Errorf("%s:%d: %s", file, line, err)
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// recordOption records opt in the config under the given name when applied,
+// along with the call site of the function calling recordOption (i.e., the
+// place the option was constructed).
+func recordOption(name string, opt Option) Option {
+	return config.NamedOption(name, callSite(2), opt)
+}
+
+// recordValueOption records options declared as values (e.g. NoTransports).
+// They're constructed when this package is initialized, so only their name is
+// recorded.
+func recordValueOption(name string, opt Option) Option {
+	return config.NamedOption(name, "", opt)
 }
@@ -0,0 +1,269 @@
+package libp2p
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// DefaultKeyType is the type of key generated by IdentityFromFile (and the
+// identity section of config files) when the key file doesn't exist and no key
+// type is given. Applications may change it before constructing their nodes.
+var DefaultKeyType = crypto.Ed25519
+
+// DefaultRSAKeyBits is the size of RSA keys generated by IdentityFromFile when
+// no size is given.
+const DefaultRSAKeyBits = 2048
+
+// encryptedKeyMagic prefixes passphrase-encrypted key files. Unencrypted key
+// files are plain marshalled private keys (protobufs) which never start with a
+// zero byte.
+var encryptedKeyMagic = []byte("\x00libp2p-encrypted-key/v1\n")
+
+// scrypt parameters used to derive the key file encryption key.
+const (
+	keyFileSaltLen = 16
+	keyFileScryptN = 1 << 15
+	keyFileScryptR = 8
+	keyFileScryptP = 1
+)
+
+// KeyFileOption configures how IdentityFromFile reads and creates key files.
+type KeyFileOption func(*keyFileOptions) error
+
+type keyFileOptions struct {
+	keyType    int
+	bits       int
+	passphrase []byte
+}
+
+// KeyType sets the type of key generated when the key file doesn't exist. The
+// type is one of the key types defined in go-libp2p-core/crypto (crypto.RSA,
+// crypto.Ed25519, crypto.Secp256k1 or crypto.ECDSA). bits is only used for RSA
+// keys; pass 0 to use DefaultRSAKeyBits.
+func KeyType(typ, bits int) KeyFileOption {
+	return func(o *keyFileOptions) error {
+		switch typ {
+		case crypto.RSA, crypto.Ed25519, crypto.Secp256k1, crypto.ECDSA:
+		default:
+			return fmt.Errorf("unsupported key type %d", typ)
+		}
+		o.keyType = typ
+		o.bits = bits
+		return nil
+	}
+}
+
+// KeyPassphrase encrypts the key file with a key derived from the given
+// passphrase. Key files created with a passphrase can only be read with the
+// same passphrase.
+func KeyPassphrase(passphrase []byte) KeyFileOption {
+	return func(o *keyFileOptions) error {
+		if len(passphrase) == 0 {
+			return fmt.Errorf("empty key file passphrase")
+		}
+		o.passphrase = passphrase
+		return nil
+	}
+}
+
+// IdentityFromFile configures libp2p to use the private key stored in the
+// given file to identify itself.
+//
+// If the file doesn't exist and createIfMissing is set, a new key is generated
+// (see KeyType) and written to the file. Otherwise, a missing file is an error.
+//
+// Key files are created with 0600 permissions and, on unix systems, key files
+// readable or writable by anyone but the owner are rejected.
+func IdentityFromFile(path string, createIfMissing bool, opts ...KeyFileOption) Option {
+	return recordOption("IdentityFromFile", func(cfg *Config) error {
+		if cfg.PeerKey != nil {
+			return fmt.Errorf("cannot specify multiple identities")
+		}
+
+		o := keyFileOptions{keyType: DefaultKeyType}
+		for _, opt := range opts {
+			if err := opt(&o); err != nil {
+				return err
+			}
+		}
+
+		sk, err := loadKeyFile(path, o.passphrase)
+		if os.IsNotExist(err) && createIfMissing {
+			sk, err = createKeyFile(path, &o)
+		}
+		if err != nil {
+			return err
+		}
+
+		cfg.PeerKey = sk
+		return nil
+	})
+}
+
+func loadKeyFile(path string, passphrase []byte) (crypto.PrivKey, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s has insecure permissions %#o; it must only be accessible by its owner", path, fi.Mode().Perm())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, encryptedKeyMagic) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("key file %s is encrypted but no passphrase was given", path)
+		}
+		data, err = decryptKey(data[len(encryptedKeyMagic):], passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt key file %s: %s", path, err)
+		}
+	} else if passphrase != nil {
+		return nil, fmt.Errorf("key file %s is not encrypted", path)
+	}
+
+	sk, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key from %s: %s", path, err)
+	}
+	return sk, nil
+}
+
+// RandomIdentityOfType configures libp2p to use a random identity with a key
+// of the given type (see KeyType), instead of the RSA key generated by
+// default (see RandomIdentity).
+func RandomIdentityOfType(typ, bits int) Option {
+	return recordOption("RandomIdentityOfType", func(cfg *Config) error {
+		if cfg.PeerKey != nil {
+			return fmt.Errorf("cannot specify multiple identities")
+		}
+
+		var o keyFileOptions
+		if err := KeyType(typ, bits)(&o); err != nil {
+			return err
+		}
+		sk, err := generateKey(&o)
+		if err != nil {
+			return err
+		}
+
+		cfg.PeerKey = sk
+		return nil
+	})
+}
+
+func generateKey(o *keyFileOptions) (crypto.PrivKey, error) {
+	bits := o.bits
+	if bits == 0 {
+		bits = DefaultRSAKeyBits
+	}
+	sk, _, err := crypto.GenerateKeyPair(o.keyType, bits)
+	return sk, err
+}
+
+func createKeyFile(path string, o *keyFileOptions) (crypto.PrivKey, error) {
+	sk, err := generateKey(o)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.MarshalPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	if o.passphrase != nil {
+		data, err = encryptKey(data, o.passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// Don't clobber a key file created concurrently.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return sk, nil
+}
+
+func keyFileAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, keyFileScryptN, keyFileScryptR, keyFileScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptKey encrypts a marshalled key with AES-GCM. The output is
+// magic || salt || nonce || ciphertext.
+func encryptKey(data, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, keyFileSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyFileAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedKeyMagic)+len(salt)+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, encryptedKeyMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, encryptedKeyMagic), nil
+}
+
+func decryptKey(data, passphrase []byte) ([]byte, error) {
+	if len(data) < keyFileSaltLen {
+		return nil, fmt.Errorf("encrypted key too short")
+	}
+	salt, data := data[:keyFileSaltLen], data[keyFileSaltLen:]
+	aead, err := keyFileAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted key too short")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, encryptedKeyMagic)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted key")
+	}
+	return plain, nil
+}
@@ -0,0 +1,167 @@
+package libp2p
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+func applyIdentity(t *testing.T, opt Option) (crypto.PrivKey, error) {
+	t.Helper()
+	var cfg Config
+	if err := cfg.Apply(opt); err != nil {
+		return nil, err
+	}
+	return cfg.PeerKey, nil
+}
+
+func TestIdentityFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := applyIdentity(t, IdentityFromFile(filepath.Join(dir, "missing"), false)); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error for a missing key file, got %v", err)
+	}
+
+	for _, typ := range []int{crypto.Ed25519, crypto.ECDSA, crypto.Secp256k1, crypto.RSA} {
+		path := filepath.Join(dir, "keys", fmt.Sprint(typ))
+		sk, err := applyIdentity(t, IdentityFromFile(path, true, KeyType(typ, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(sk.Type()) != typ {
+			t.Errorf("expected a key of type %d, got %d", typ, sk.Type())
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("expected key file permissions 0600, got %#o", fi.Mode().Perm())
+		}
+
+		loaded, err := applyIdentity(t, IdentityFromFile(path, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.Equals(sk) {
+			t.Error("expected the loaded key to match the generated key")
+		}
+	}
+
+	// default key type
+	sk, err := applyIdentity(t, IdentityFromFile(filepath.Join(dir, "default"), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(sk.Type()) != DefaultKeyType {
+		t.Errorf("expected a key of the default type, got %d", sk.Type())
+	}
+
+	defer func(typ int) { DefaultKeyType = typ }(DefaultKeyType)
+	DefaultKeyType = crypto.Secp256k1
+	sk, err = applyIdentity(t, IdentityFromFile(filepath.Join(dir, "changed-default"), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk.Type() != crypto.Secp256k1 {
+		t.Errorf("expected a key of the changed default type, got %d", sk.Type())
+	}
+
+	if _, err := applyIdentity(t, IdentityFromFile(filepath.Join(dir, "bad"), true, KeyType(42, 0))); err == nil {
+		t.Error("expected an unsupported key type to fail")
+	}
+
+	var cfg Config
+	if err := cfg.Apply(RandomIdentity, IdentityFromFile(filepath.Join(dir, "default"), false)); err == nil {
+		t.Error("expected multiple identities to fail")
+	}
+}
+
+func TestIdentityFromFileEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key")
+	pass := KeyPassphrase([]byte("correct horse"))
+	sk, err := applyIdentity(t, IdentityFromFile(path, true, pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crypto.UnmarshalPrivateKey(data); err == nil {
+		t.Error("expected the key file to be encrypted")
+	}
+
+	loaded, err := applyIdentity(t, IdentityFromFile(path, false, pass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equals(sk) {
+		t.Error("expected the decrypted key to match the generated key")
+	}
+
+	if _, err := applyIdentity(t, IdentityFromFile(path, false)); err == nil {
+		t.Error("expected loading an encrypted key without a passphrase to fail")
+	}
+	if _, err := applyIdentity(t, IdentityFromFile(path, true, KeyPassphrase([]byte("wrong")))); err == nil {
+		t.Error("expected loading an encrypted key with the wrong passphrase to fail")
+	}
+
+	plain := filepath.Join(dir, "plain")
+	if _, err := applyIdentity(t, IdentityFromFile(plain, true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyIdentity(t, IdentityFromFile(plain, false, pass)); err == nil {
+		t.Error("expected loading an unencrypted key with a passphrase to fail")
+	}
+}
+
+func TestIdentityFromFilePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key")
+	if _, err := applyIdentity(t, IdentityFromFile(path, true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyIdentity(t, IdentityFromFile(path, false)); err == nil {
+		t.Error("expected loading a world readable key file to fail")
+	}
+}
+
+func TestRandomIdentityOfType(t *testing.T) {
+	var cfg Config
+	if err := cfg.Apply(RandomIdentityOfType(crypto.Ed25519, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PeerKey == nil || cfg.PeerKey.Type() != crypto.Ed25519 {
+		t.Fatal("expected an ed25519 key")
+	}
+
+	cfg = Config{}
+	if err := cfg.Apply(RandomIdentityOfType(42, 0)); err == nil {
+		t.Error("expected an unsupported key type to fail")
+	}
+}
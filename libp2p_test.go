@@ -2,15 +2,36 @@ package libp2p
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/transport"
 	"github.com/libp2p/go-tcp-transport"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	secio "github.com/libp2p/go-libp2p-secio"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	filter "github.com/libp2p/go-maddr-filter"
+
+	config "github.com/libp2p/go-libp2p/config"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func TestNewHost(t *testing.T) {
@@ -163,3 +184,510 @@ func TestChainOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestComponentsByName(t *testing.T) {
+	ctx := context.Background()
+	h, err := New(
+		ctx,
+		TransportByName("tcp"),
+		MuxerByName("yamux"),
+		SecurityByName("secio"),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	for _, opt := range []Option{TransportByName("foo"), MuxerByName("foo"), SecurityByName("foo")} {
+		h, err := New(ctx, opt)
+		if err == nil {
+			h.Close()
+			t.Error("expected an error for an unknown component")
+		}
+	}
+
+	if _, err := New(ctx, NoSecurity, SecurityByName("secio")); err == nil {
+		t.Error("expected an error when combining NoSecurity with a security transport")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var cfg Config
+	if err := cfg.Apply(ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), FallbackDefaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = Config{}
+	if err := cfg.Apply(
+		Transport(tcp.NewTCPTransport),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/tcp/0/ws"),
+		DisableRelay(),
+		EnableAutoRelay(),
+		FallbackDefaults,
+	); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.Validate()
+	verr, ok := err.(*config.ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if len(verr.Errors) != 2 {
+		t.Fatalf("expected two errors, got: %s", err)
+	}
+	if !strings.Contains(verr.Errors[0].Error(), "relay is not enabled") {
+		t.Errorf("expected an autorelay error, got: %s", verr.Errors[0])
+	}
+	if !strings.Contains(verr.Errors[1].Error(), "/ws") {
+		t.Errorf("expected an error for the websocket listen address, got: %s", verr.Errors[1])
+	}
+
+	// the security transports, muxers and transports are constructed against
+	// a host without a usable network.
+	var constructed []string
+	cfg = Config{}
+	if err := cfg.Apply(
+		Security("/test", func(sk crypto.PrivKey) (*secio.Transport, error) {
+			constructed = append(constructed, "security")
+			return secio.New(sk)
+		}),
+		Transport(func(n network.Network, u *tptu.Upgrader) *tcp.TcpTransport {
+			constructed = append(constructed, "transport")
+			return tcp.NewTCPTransport(u)
+		}),
+		ListenAddrStrings("/ip4/127.0.0.1/udp/0/quic"),
+		FallbackDefaults,
+	); err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "no transport for listen address /ip4/127.0.0.1/udp/0/quic") {
+		t.Errorf("expected an error for the quic listen address, got: %v", err)
+	}
+	if len(constructed) != 2 {
+		t.Errorf("expected the security transport and the transport to be constructed, got %v", constructed)
+	}
+
+	// transports using the network at construction aren't checked, the
+	// others still are.
+	cfg = Config{}
+	if err := cfg.Apply(
+		Transport(tcp.NewTCPTransport),
+		Transport(func(n network.Network, u *tptu.Upgrader) *tcp.TcpTransport {
+			n.Peers()
+			return tcp.NewTCPTransport(u)
+		}),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic", "/p2p-circuit"),
+		DisableRelay(),
+		FallbackDefaults,
+	); err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	verr, ok = err.(*config.ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if len(verr.Errors) != 1 || !strings.Contains(verr.Errors[0].Error(), "relay is not enabled") {
+		t.Errorf("expected only the relay listen address error, got: %s", err)
+	}
+}
+
+func TestListenStrict(t *testing.T) {
+	ctx := context.Background()
+	addrs := ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/1.2.3.4/tcp/0")
+
+	h, err := New(ctx, addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	h, err = New(ctx, addrs, ListenStrict())
+	if err == nil {
+		h.Close()
+		t.Fatal("expected strict listening to fail")
+	}
+	lerr, ok := err.(*config.ListenError)
+	if !ok {
+		t.Fatalf("expected a listen error, got %v", err)
+	}
+	if len(lerr.Failures) != 1 || lerr.Failures[0].Addr.String() != "/ip4/1.2.3.4/tcp/0" {
+		t.Errorf("expected /ip4/1.2.3.4/tcp/0 to fail, got: %s", err)
+	}
+}
+
+func TestCustomNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	var hosts []host.Host
+	for i := 0; i < 2; i++ {
+		h, err := New(ctx,
+			Network(mn.AddPeerNetwork),
+			NoTransports,
+			DisableRelay(),
+			NegotiationTimeout(-1),
+			ListenAddrStrings(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 4000+i)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		hosts = append(hosts, h)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	hosts[1].SetStreamHandler(protocol.TestingID, func(s network.Stream) {
+		defer s.Reset()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(s, buf); err == nil && string(buf) == "ping" {
+			close(done)
+		}
+	})
+	if err := hosts[0].Connect(ctx, peer.AddrInfo{ID: hosts[1].ID()}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := hosts[0].NewStream(ctx, hosts[1].ID(), protocol.TestingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// force protocol negotiation.
+	if _, err := s.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream handler wasn't called")
+	}
+
+	if _, err := New(ctx, Network(mn.AddPeerNetwork)); err == nil {
+		t.Error("expected adding transports to a mock network to fail")
+	}
+	if _, err := New(ctx, Network(func(string) network.Network { return nil })); err == nil {
+		t.Error("expected an invalid network constructor to fail")
+	}
+
+	// no bandwidth reporter is configured, the swarm gets a nil one.
+	h, err := New(ctx, Network(swarm.NewSwarm), ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if len(h.Network().ListenAddresses()) == 0 {
+		t.Error("expected the swarm to listen")
+	}
+}
+
+func TestDialRanker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := New(ctx, DialRanker(bhost.NoDelayDialRanker)); err == nil {
+		t.Error("expected ranking dials on the swarm to fail")
+	}
+
+	mn := mocknet.New(ctx)
+	h, err := New(ctx,
+		Network(mn.AddPeerNetwork),
+		NoTransports,
+		DisableRelay(),
+		NegotiationTimeout(-1),
+		DialRanker(bhost.NoDelayDialRanker),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+}
+
+type testService struct {
+	name   string
+	closed *[]string
+}
+
+func (s *testService) Close() error {
+	*s.closed = append(*s.closed, s.name)
+	return nil
+}
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+
+	var closed []string
+	var bus event.Bus
+	h, err := New(ctx,
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		Service(func(h host.Host) *testService {
+			h.SetStreamHandler(protocol.TestingID, func(s network.Stream) { s.Reset() })
+			return &testService{name: "first", closed: &closed}
+		}),
+		Service(func(id peer.ID, b event.Bus) (*testService, error) {
+			bus = b
+			return &testService{name: "second", closed: &closed}, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bus != h.EventBus() {
+		t.Error("expected the service to be passed the host's event bus")
+	}
+	found := false
+	for _, p := range h.Mux().Protocols() {
+		if p == string(protocol.TestingID) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the service to register its stream handler before New returns")
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 2 || closed[0] != "second" || closed[1] != "first" {
+		t.Errorf("expected services to be closed in reverse order, got %v", closed)
+	}
+
+	closed = nil
+	_, err = New(ctx,
+		NoListenAddrs,
+		Service(func() *testService { return &testService{name: "first", closed: &closed} }),
+		Service(func() (*testService, error) { return nil, fmt.Errorf("failed") }),
+	)
+	if err == nil {
+		t.Fatal("expected a failing service constructor to fail")
+	}
+	if len(closed) != 1 || closed[0] != "first" {
+		t.Errorf("expected the constructed service to be closed, got %v", closed)
+	}
+
+	if _, err := New(ctx, Service(func(string) *testService { return nil })); err == nil {
+		t.Error("expected an invalid service constructor to fail")
+	}
+}
+
+// listenHookTransport is a TCP transport calling onListen whenever it starts
+// listening.
+type listenHookTransport struct {
+	*tcp.TcpTransport
+	onListen func(ma.Multiaddr)
+}
+
+func (t *listenHookTransport) Listen(a ma.Multiaddr) (transport.Listener, error) {
+	l, err := t.TcpTransport.Listen(a)
+	if err != nil {
+		return nil, err
+	}
+	t.onListen(l.Multiaddr())
+	return l, nil
+}
+
+func TestServiceBeforeListen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2, err := New(ctx, NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	// Connect to the node as soon as it listens, while New is still running,
+	// and open a stream for the protocol registered by the service. The
+	// connection is established without the node accepting it, but
+	// identifying it would block until New returns.
+	result := make(chan error, 1)
+	onListen := func(addr ma.Multiaddr) {
+		h2.Peerstore().AddAddr(pid, addr, peerstore.TempAddrTTL)
+		if _, err := h2.Network().DialPeer(ctx, pid); err != nil {
+			result <- err
+			return
+		}
+		go func() {
+			s, err := h2.NewStream(ctx, pid, protocol.TestingID)
+			if err != nil {
+				result <- err
+				return
+			}
+			defer s.Close()
+			s.SetReadDeadline(time.Now().Add(10 * time.Second))
+			_, err = s.Read(make([]byte, 1))
+			result <- err
+		}()
+	}
+
+	var listening bool
+	h, err := New(ctx,
+		Identity(priv),
+		Transport(func(u *tptu.Upgrader) *listenHookTransport {
+			return &listenHookTransport{TcpTransport: tcp.NewTCPTransport(u), onListen: onListen}
+		}),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DisableRelay(),
+		Service(func(h host.Host) *testService {
+			listening = len(h.Network().ListenAddresses()) > 0
+			h.SetStreamHandler(protocol.TestingID, func(s network.Stream) {
+				s.Write([]byte("x"))
+				s.Close()
+			})
+			return &testService{closed: new([]string)}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if listening {
+		t.Error("expected services to be constructed before the node listens")
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("expected the stream opened during New to be handled, got: %s", err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	ctx := context.Background()
+	h, err := New(ctx,
+		Transport(tcp.NewTCPTransport),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		DisableRelay(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	desc := h.(bhost.Describer).Describe()
+	if desc.ID != h.ID() {
+		t.Errorf("expected ID %s, got %s", h.ID(), desc.ID)
+	}
+	if len(desc.ListenAddrs) != 1 {
+		t.Errorf("expected one listen addr, got %v", desc.ListenAddrs)
+	}
+	cd, ok := desc.Config.(*config.Description)
+	if !ok {
+		t.Fatalf("expected a config description, got %T", desc.Config)
+	}
+	if len(cd.Transports) != 1 || len(cd.Transports[0].Protocols) != 1 || cd.Transports[0].Protocols[0] != "tcp" {
+		t.Errorf("expected a single tcp transport, got %v", cd.Transports)
+	}
+	if len(cd.Muxers) != 2 || len(cd.Security) != 1 {
+		t.Errorf("expected the default muxers and security transports, got %v and %v", cd.Muxers, cd.Security)
+	}
+	if cd.Relay.Enabled {
+		t.Error("expected relay to be disabled")
+	}
+
+	var userOpt, defaultOpt bool
+	for _, opt := range cd.Options {
+		switch {
+		case opt.Name == "Transport" && !opt.Default:
+			userOpt = strings.Contains(opt.Source, "libp2p_test.go")
+		case opt.Name == "Muxer" && opt.Default:
+			defaultOpt = strings.Contains(opt.Source, "defaults.go")
+		}
+	}
+	if !userOpt {
+		t.Errorf("expected the Transport option to be recorded with its call site, got %v", cd.Options)
+	}
+	if !defaultOpt {
+		t.Errorf("expected the default muxers to be recorded as defaults, got %v", cd.Options)
+	}
+
+	if _, err := json.Marshal(desc); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := cfg.Apply(NATPortMap()); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Applied) != 1 || cfg.Applied[0].Name != "NATPortMap" {
+		t.Errorf("expected NATPortMap to be recorded once, got %v", cfg.Applied)
+	}
+}
+
+func TestOptionConflicts(t *testing.T) {
+	_, ipnet, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// options declared as values (e.g. NoListenAddrs) have no call site.
+	for _, tc := range []struct {
+		opts  []Option
+		sites int
+	}{
+		{[]Option{FilterAddresses(ipnet), Filters(filter.NewFilters())}, 2},
+		{[]Option{ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), NoListenAddrs}, 1},
+		{[]Option{EnableRelay(circuit.OptHop), EnableRelay()}, 2},
+		{[]Option{Transport(tcp.NewTCPTransport), NoTransports}, 1},
+	} {
+		var cfg Config
+		err := cfg.Apply(tc.opts...)
+		cerr, ok := err.(*config.ConflictError)
+		if !ok {
+			t.Errorf("expected a conflict error, got %v", err)
+			continue
+		}
+		if strings.Count(cerr.Error(), "libp2p_test.go") != tc.sites {
+			t.Errorf("expected the error to name the call sites, got %q", cerr)
+		}
+
+		cfg = Config{}
+		if err := cfg.Apply(append([]Option{LenientOptions()}, tc.opts...)...); err != nil {
+			t.Errorf("expected lenient options not to fail, got %s", err)
+		}
+	}
+
+	// Extending and repeating settings is fine.
+	var cfg Config
+	if err := cfg.Apply(
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/1"),
+		Filters(filter.NewFilters()),
+		FilterAddresses(ipnet),
+		EnableRelay(circuit.OptHop),
+		EnableRelay(circuit.OptHop),
+		NoTransports,
+		Transport(tcp.NewTCPTransport),
+		FallbackDefaults,
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverrideDefaults(t *testing.T) {
+	for _, opts := range [][]Option{
+		{Defaults, DisableRelay()},
+		{Defaults, NoTransports, Transport(tcp.NewTCPTransport)},
+		{Defaults, NoListenAddrs},
+	} {
+		var cfg Config
+		if err := cfg.Apply(append(opts, FallbackDefaults)...); err != nil {
+			t.Errorf("expected options not to conflict with defaults, got %s", err)
+		}
+	}
+}
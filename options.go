@@ -6,6 +6,7 @@ package libp2p
 import (
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/connmgr"
 	"github.com/libp2p/go-libp2p-core/crypto"
@@ -18,6 +19,7 @@ import (
 	config "github.com/libp2p/go-libp2p/config"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 	autorelay "github.com/libp2p/go-libp2p/p2p/host/relay"
+	"github.com/libp2p/go-libp2p/p2p/net/resolver"
 
 	filter "github.com/libp2p/go-maddr-filter"
 	ma "github.com/multiformats/go-multiaddr"
@@ -26,7 +28,7 @@ import (
 // ListenAddrStrings configures libp2p to listen on the given (unparsed)
 // addresses.
 func ListenAddrStrings(s ...string) Option {
-	return func(cfg *Config) error {
+	return recordOption("ListenAddrStrings", func(cfg *Config) error {
 		for _, addrstr := range s {
 			a, err := ma.NewMultiaddr(addrstr)
 			if err != nil {
@@ -35,15 +37,27 @@ func ListenAddrStrings(s ...string) Option {
 			cfg.ListenAddrs = append(cfg.ListenAddrs, a)
 		}
 		return nil
-	}
+	})
 }
 
 // ListenAddrs configures libp2p to listen on the given addresses.
 func ListenAddrs(addrs ...ma.Multiaddr) Option {
-	return func(cfg *Config) error {
+	return recordOption("ListenAddrs", func(cfg *Config) error {
 		cfg.ListenAddrs = append(cfg.ListenAddrs, addrs...)
 		return nil
-	}
+	})
+}
+
+// ListenStrict configures libp2p to fail node construction if listening on
+// *any* of the configured listen addresses fails. By default, libp2p only fails
+// if it can't listen on any address at all.
+//
+// The returned error is a *config.ListenError listing the failed addresses.
+func ListenStrict() Option {
+	return recordOption("ListenStrict", func(cfg *Config) error {
+		cfg.ListenStrict = true
+		return nil
+	})
 }
 
 // Security configures libp2p to use the given security transport (or transport
@@ -62,7 +76,7 @@ func ListenAddrs(addrs ...ma.Multiaddr) Option {
 func Security(name string, tpt interface{}) Option {
 	stpt, err := config.SecurityConstructor(tpt)
 	err = traceError(err, 1)
-	return func(cfg *Config) error {
+	return recordOption("Security", func(cfg *Config) error {
 		if err != nil {
 			return err
 		}
@@ -71,18 +85,18 @@ func Security(name string, tpt interface{}) Option {
 		}
 		cfg.SecurityTransports = append(cfg.SecurityTransports, config.MsSecC{SecC: stpt, ID: name})
 		return nil
-	}
+	})
 }
 
 // NoSecurity is an option that completely disables all transport security.
 // It's incompatible with all other transport security protocols.
-var NoSecurity Option = func(cfg *Config) error {
+var NoSecurity = recordValueOption("NoSecurity", func(cfg *Config) error {
 	if len(cfg.SecurityTransports) > 0 {
 		return fmt.Errorf("cannot use security transports with an insecure libp2p configuration")
 	}
 	cfg.Insecure = true
 	return nil
-}
+})
 
 // Muxer configures libp2p to use the given stream multiplexer (or stream
 // multiplexer constructor).
@@ -98,13 +112,13 @@ var NoSecurity Option = func(cfg *Config) error {
 func Muxer(name string, tpt interface{}) Option {
 	mtpt, err := config.MuxerConstructor(tpt)
 	err = traceError(err, 1)
-	return func(cfg *Config) error {
+	return recordOption("Muxer", func(cfg *Config) error {
 		if err != nil {
 			return err
 		}
 		cfg.Muxers = append(cfg.Muxers, config.MsMuxC{MuxC: mtpt, ID: name})
 		return nil
-	}
+	})
 }
 
 // Transport configures libp2p to use the given transport (or transport
@@ -120,88 +134,197 @@ func Muxer(name string, tpt interface{}) Option {
 // * Peer ID
 // * Private Key
 // * Public Key
-// * Address filter (filter.Filter)
+// * Address filters (*filter.Filters)
 // * Peerstore
 func Transport(tpt interface{}) Option {
 	tptc, err := config.TransportConstructor(tpt)
 	err = traceError(err, 1)
-	return func(cfg *Config) error {
+	return recordOption("Transport", func(cfg *Config) error {
 		if err != nil {
 			return err
 		}
 		cfg.Transports = append(cfg.Transports, tptc)
 		return nil
-	}
+	})
+}
+
+// TransportByName configures libp2p to use the transport registered under the
+// given name (see config.RegisterTransport).
+//
+// The default transports are registered as "tcp" and "ws".
+func TransportByName(name string) Option {
+	return recordOption("TransportByName", func(cfg *Config) error {
+		tptc, err := config.LookupTransport(name)
+		if err != nil {
+			return err
+		}
+		cfg.Transports = append(cfg.Transports, tptc)
+		return nil
+	})
+}
+
+// MuxerByName configures libp2p to use the stream multiplexer registered under
+// the given name (see config.RegisterMuxer).
+//
+// The default muxers are registered as "yamux" and "mplex".
+func MuxerByName(name string) Option {
+	return recordOption("MuxerByName", func(cfg *Config) error {
+		muxc, err := config.LookupMuxer(name)
+		if err != nil {
+			return err
+		}
+		cfg.Muxers = append(cfg.Muxers, muxc)
+		return nil
+	})
+}
+
+// SecurityByName configures libp2p to use the security transport registered
+// under the given name (see config.RegisterSecurity).
+//
+// The default security transport is registered as "secio".
+func SecurityByName(name string) Option {
+	return recordOption("SecurityByName", func(cfg *Config) error {
+		secc, err := config.LookupSecurity(name)
+		if err != nil {
+			return err
+		}
+		if cfg.Insecure {
+			return fmt.Errorf("cannot use security transports with an insecure libp2p configuration")
+		}
+		cfg.SecurityTransports = append(cfg.SecurityTransports, secc)
+		return nil
+	})
+}
+
+// Network configures libp2p to use the given network (or network constructor)
+// instead of the default swarm.
+//
+// The network can be a constructed network.Network or a function taking any
+// subset of this libp2p node's:
+// * Context
+// * Peer ID
+// * Private Key
+// * Public Key
+// * Peerstore
+// * Bandwidth reporter (metrics.Reporter)
+// * Address filters (*filter.Filters)
+//
+// Transports are added to the network as usual, so it must implement
+// transport.TransportNetwork unless combined with NoTransports.
+func Network(n interface{}) Option {
+	netc, err := config.NetworkConstructor(n)
+	err = traceError(err, 1)
+	return recordOption("Network", func(cfg *Config) error {
+		if err != nil {
+			return err
+		}
+		if cfg.Network != nil {
+			return fmt.Errorf("cannot specify multiple network options")
+		}
+		cfg.Network = netc
+		return nil
+	})
+}
+
+// Service constructs a service when the libp2p node is constructed and closes
+// it when the node shuts down.
+//
+// The constructor is a function taking any subset of this libp2p node's:
+// * Host
+// * Network
+// * Peer ID
+// * Private Key
+// * Public Key
+// * Peerstore
+// * Event bus
+//
+// And returning a type implementing io.Closer and, optionally, an error.
+//
+// Services are constructed in the order they're specified, once routing is
+// configured but before the node starts listening, so the stream handlers they
+// register are in place for the first inbound connection. The host doesn't
+// have any listen addresses yet when they're constructed. They're closed in
+// reverse order before the network shuts down.
+func Service(constructor interface{}) Option {
+	svcC, err := config.ServiceConstructor(constructor)
+	err = traceError(err, 1)
+	return recordOption("Service", func(cfg *Config) error {
+		if err != nil {
+			return err
+		}
+		cfg.Services = append(cfg.Services, svcC)
+		return nil
+	})
 }
 
 // Peerstore configures libp2p to use the given peerstore.
 func Peerstore(ps peerstore.Peerstore) Option {
-	return func(cfg *Config) error {
+	return recordOption("Peerstore", func(cfg *Config) error {
 		if cfg.Peerstore != nil {
 			return fmt.Errorf("cannot specify multiple peerstore options")
 		}
 
 		cfg.Peerstore = ps
 		return nil
-	}
+	})
 }
 
 // PrivateNetwork configures libp2p to use the given private network protector.
 func PrivateNetwork(psk pnet.PSK) Option {
-	return func(cfg *Config) error {
+	return recordOption("PrivateNetwork", func(cfg *Config) error {
 		if cfg.PSK != nil {
 			return fmt.Errorf("cannot specify multiple private network options")
 		}
 
 		cfg.PSK = psk
 		return nil
-	}
+	})
 }
 
 // BandwidthReporter configures libp2p to use the given bandwidth reporter.
 func BandwidthReporter(rep metrics.Reporter) Option {
-	return func(cfg *Config) error {
+	return recordOption("BandwidthReporter", func(cfg *Config) error {
 		if cfg.Reporter != nil {
 			return fmt.Errorf("cannot specify multiple bandwidth reporter options")
 		}
 
 		cfg.Reporter = rep
 		return nil
-	}
+	})
 }
 
 // Identity configures libp2p to use the given private key to identify itself.
 func Identity(sk crypto.PrivKey) Option {
-	return func(cfg *Config) error {
+	return recordOption("Identity", func(cfg *Config) error {
 		if cfg.PeerKey != nil {
 			return fmt.Errorf("cannot specify multiple identities")
 		}
 
 		cfg.PeerKey = sk
 		return nil
-	}
+	})
 }
 
 // ConnectionManager configures libp2p to use the given connection manager.
 func ConnectionManager(connman connmgr.ConnManager) Option {
-	return func(cfg *Config) error {
+	return recordOption("ConnectionManager", func(cfg *Config) error {
 		if cfg.ConnManager != nil {
 			return fmt.Errorf("cannot specify multiple connection managers")
 		}
 		cfg.ConnManager = connman
 		return nil
-	}
+	})
 }
 
 // AddrsFactory configures libp2p to use the given address factory.
 func AddrsFactory(factory config.AddrsFactory) Option {
-	return func(cfg *Config) error {
+	return recordOption("AddrsFactory", func(cfg *Config) error {
 		if cfg.AddrsFactory != nil {
 			return fmt.Errorf("cannot specify multiple address factories")
 		}
 		cfg.AddrsFactory = factory
 		return nil
-	}
+	})
 }
 
 // EnableRelay configures libp2p to enable the relay transport with
@@ -211,21 +334,21 @@ func AddrsFactory(factory config.AddrsFactory) Option {
 //
 // To _act_ as a relay, pass the circuit.OptHop option.
 func EnableRelay(options ...circuit.RelayOpt) Option {
-	return func(cfg *Config) error {
+	return recordOption("EnableRelay", func(cfg *Config) error {
 		cfg.RelayCustom = true
 		cfg.Relay = true
 		cfg.RelayOpts = options
 		return nil
-	}
+	})
 }
 
 // DisableRelay configures libp2p to disable the relay transport.
 func DisableRelay() Option {
-	return func(cfg *Config) error {
+	return recordOption("DisableRelay", func(cfg *Config) error {
 		cfg.RelayCustom = true
 		cfg.Relay = false
 		return nil
-	}
+	})
 }
 
 // EnableAutoRelay configures libp2p to enable the AutoRelay subsystem. It is an
@@ -241,25 +364,25 @@ func DisableRelay() Option {
 //    automatically detect if it is unreachable (e.g., behind a NAT). If so, it will
 //    find, configure, and announce a set of public relays.
 func EnableAutoRelay() Option {
-	return func(cfg *Config) error {
+	return recordOption("EnableAutoRelay", func(cfg *Config) error {
 		cfg.EnableAutoRelay = true
 		return nil
-	}
+	})
 }
 
 // StaticRelays configures known relays for autorelay; when this option is enabled
 // then the system will use the configured relays instead of querying the DHT to
 // discover relays.
 func StaticRelays(relays []peer.AddrInfo) Option {
-	return func(cfg *Config) error {
+	return recordOption("StaticRelays", func(cfg *Config) error {
 		cfg.StaticRelays = append(cfg.StaticRelays, relays...)
 		return nil
-	}
+	})
 }
 
 // DefaultStaticRelays configures the static relays to use the known PL-operated relays.
 func DefaultStaticRelays() Option {
-	return func(cfg *Config) error {
+	return recordOption("DefaultStaticRelays", func(cfg *Config) error {
 		for _, addr := range autorelay.DefaultRelays {
 			a, err := ma.NewMultiaddr(addr)
 			if err != nil {
@@ -273,14 +396,14 @@ func DefaultStaticRelays() Option {
 		}
 
 		return nil
-	}
+	})
 }
 
 // FilterAddresses configures libp2p to never dial nor accept connections from
 // the given addresses. FilterAddresses should be used for cases where the
 // addresses you want to deny are known ahead of time.
 func FilterAddresses(addrs ...*net.IPNet) Option {
-	return func(cfg *Config) error {
+	return recordOption("FilterAddresses", func(cfg *Config) error {
 		if cfg.Filters == nil {
 			cfg.Filters = filter.NewFilters()
 		}
@@ -288,7 +411,7 @@ func FilterAddresses(addrs ...*net.IPNet) Option {
 			cfg.Filters.AddDialFilter(addr)
 		}
 		return nil
-	}
+	})
 }
 
 // Filters configures libp2p to use the given filters for accepting/denying
@@ -296,21 +419,25 @@ func FilterAddresses(addrs ...*net.IPNet) Option {
 // addresses you want to accept/deny are not known ahead of time and can
 // dynamically change.
 func Filters(filters *filter.Filters) Option {
-	return func(cfg *Config) error {
+	return recordOption("Filters", func(cfg *Config) error {
 		cfg.Filters = filters
 		return nil
-	}
+	})
 }
 
 // NATPortMap configures libp2p to use the default NATManager. The default
 // NATManager will attempt to open a port in your network's firewall using UPnP.
 func NATPortMap() Option {
-	return NATManager(bhost.NewNATManager)
+	return recordOption("NATPortMap", natManager(bhost.NewNATManager))
 }
 
 // NATManager will configure libp2p to use the requested NATManager. This
 // function should be passed a NATManager *constructor* that takes a libp2p Network.
 func NATManager(nm config.NATManagerC) Option {
+	return recordOption("NATManager", natManager(nm))
+}
+
+func natManager(nm config.NATManagerC) Option {
 	return func(cfg *Config) error {
 		if cfg.NATManager != nil {
 			return fmt.Errorf("cannot specify multiple NATManagers")
@@ -322,51 +449,114 @@ func NATManager(nm config.NATManagerC) Option {
 
 // Ping will configure libp2p to support the ping service; enable by default.
 func Ping(enable bool) Option {
-	return func(cfg *Config) error {
+	return recordOption("Ping", func(cfg *Config) error {
 		cfg.DisablePing = !enable
 		return nil
-	}
+	})
 }
 
 // Routing will configure libp2p to use routing.
 func Routing(rt config.RoutingC) Option {
-	return func(cfg *Config) error {
+	return recordOption("Routing", func(cfg *Config) error {
 		if cfg.Routing != nil {
 			return fmt.Errorf("cannot specify multiple routing options")
 		}
 		cfg.Routing = rt
 		return nil
-	}
+	})
 }
 
 // NoListenAddrs will configure libp2p to not listen by default.
 //
-// This will both clear any configured listen addrs and prevent libp2p from
-// applying the default listen address option. It also disables relay, unless the
+// This will prevent libp2p from applying the default listen address option.
+// Applying it after options configuring listen addresses is a conflict (unless
+// options are lenient, see LenientOptions). It also disables relay, unless the
 // user explicitly specifies with an option, as the transport creates an implicit
 // listen address that would make the node dialable through any relay it was connected to.
-var NoListenAddrs = func(cfg *Config) error {
+var NoListenAddrs = recordValueOption("NoListenAddrs", func(cfg *Config) error {
 	cfg.ListenAddrs = []ma.Multiaddr{}
 	if !cfg.RelayCustom {
 		cfg.RelayCustom = true
 		cfg.Relay = false
 	}
 	return nil
-}
+})
 
 // NoTransports will configure libp2p to not enable any transports.
 //
-// This will both clear any configured transports (specified in prior libp2p
-// options) and prevent libp2p from applying the default transports.
-var NoTransports = func(cfg *Config) error {
+// This will prevent libp2p from applying the default transports. Applying it
+// after options configuring transports is a conflict (unless options are
+// lenient, see LenientOptions).
+var NoTransports = recordValueOption("NoTransports", func(cfg *Config) error {
 	cfg.Transports = []config.TptC{}
 	return nil
+})
+
+// NegotiationTimeout configures the timeout for negotiating the protocol of
+// inbound streams. A negative timeout disables it, which is required for
+// networks whose streams don't support deadlines (e.g. mocknet).
+func NegotiationTimeout(timeout time.Duration) Option {
+	return recordOption("NegotiationTimeout", func(cfg *Config) error {
+		cfg.NegotiationTimeout = timeout
+		return nil
+	})
+}
+
+// DialRanker configures how the host orders and staggers dials to a peer's
+// addresses (see bhost.HostOpts.DialRanker).
+//
+// Dials can only be ranked on networks able to dial a specific address (see
+// bhost.AddrDialer). The swarm isn't one of them: it dials all the addresses
+// of a peer itself, so configuring a ranker without a custom network (see
+// Network) fails.
+func DialRanker(r bhost.DialRanker) Option {
+	return recordOption("DialRanker", func(cfg *Config) error {
+		cfg.DialRanker = r
+		return nil
+	})
+}
+
+// DNSResolver configures the backend resolving the DNS addresses of peers
+// before dialing them, e.g., a resolver.DoH or resolver.Hosts. Each host
+// caches the lookups according to their TTLs, unless the backend already is a
+// *resolver.Cache (see bhost.HostOpts.DNSResolver). The lookups of backends not
+// reporting TTLs, like the system resolver, are only cached for
+// resolver.DefaultMinTTL.
+func DNSResolver(backend resolver.Backend) Option {
+	return recordOption("DNSResolver", func(cfg *Config) error {
+		cfg.DNSResolver = backend
+		return nil
+	})
+}
+
+// AddrResolutionLimits limits the resolution of the DNS addresses of a peer
+// to the given total number of resolutions, nesting of /dnsaddr records and
+// number of addresses used from a single resolution. Zero limits keep the
+// defaults; negative limits are disabled.
+func AddrResolutionLimits(maxResolutions, maxDepth, maxFanout int) Option {
+	return recordOption("AddrResolutionLimits", func(cfg *Config) error {
+		cfg.MaxAddressResolution = maxResolutions
+		cfg.MaxResolveDepth = maxDepth
+		cfg.MaxResolveFanout = maxFanout
+		return nil
+	})
+}
+
+// LenientOptions makes libp2p log a warning instead of failing when an option
+// overrides a setting made by a previous option (e.g., Filters replacing
+// filters configured with FilterAddresses). It only affects the options that
+// follow it.
+func LenientOptions() Option {
+	return recordOption("LenientOptions", func(cfg *Config) error {
+		cfg.Lenient = true
+		return nil
+	})
 }
 
 // UserAgent sets the libp2p user-agent sent along with the identify protocol
 func UserAgent(userAgent string) Option {
-	return func(cfg *Config) error {
+	return recordOption("UserAgent", func(cfg *Config) error {
 		cfg.UserAgent = userAgent
 		return nil
-	}
+	})
 }
@@ -0,0 +1,59 @@
+package basichost
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+)
+
+// How often we check whether our addresses changed. Changes of our listeners
+// and the ones signaled with SignalAddressChange are picked up right away, so
+// this is only a slow fallback catching the others, e.g., of NAT mappings and
+// observed addresses, and listeners closed on networks that don't notify us.
+var addrCheckInterval = time.Minute
+
+// SignalAddressChange tells the host that its addresses may have changed,
+// e.g., because the AddrsFactory returns different addresses. The host checks
+// its addresses and emits an EvtLocalAddressesUpdated if they changed.
+func (h *BasicHost) SignalAddressChange() {
+	select {
+	case h.addrChanged <- struct{}{}:
+	default:
+	}
+}
+
+// updateAddrs emits an EvtLocalAddressesUpdated if our addresses changed
+// since the last check.
+func (h *BasicHost) updateAddrs() {
+	// the lock orders the events.
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	addrs := h.Addrs()
+	added, removed := diffAddrs(h.lastAddrs, addrs)
+	h.lastAddrs = addrs
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	evt := event.EvtLocalAddressesUpdated{
+		Diffs:   true,
+		Current: make([]event.UpdatedAddress, 0, len(addrs)),
+		Removed: make([]event.UpdatedAddress, 0, len(removed)),
+	}
+	isAdded := make(map[string]bool, len(added))
+	for _, a := range added {
+		isAdded[string(a.Bytes())] = true
+	}
+	for _, a := range addrs {
+		action := event.Maintained
+		if isAdded[string(a.Bytes())] {
+			action = event.Added
+		}
+		evt.Current = append(evt.Current, event.UpdatedAddress{Address: a, Action: action})
+	}
+	for _, a := range removed {
+		evt.Removed = append(evt.Removed, event.UpdatedAddress{Address: a, Action: event.Removed})
+	}
+	h.emitters.evtLocalAddrsUpdated.Emit(evt)
+}
@@ -2,11 +2,13 @@ package basichost
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/p2p/net/resolver"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 
@@ -31,10 +33,6 @@ import (
 	msmux "github.com/multiformats/go-multistream"
 )
 
-// The maximum number of address resolution steps we'll perform for a single
-// peer (for all addresses).
-const maxAddressResolution = 32
-
 var log = logging.Logger("basichost")
 
 var (
@@ -80,7 +78,14 @@ type BasicHost struct {
 
 	AddrsFactory AddrsFactory
 
-	negtimeout time.Duration
+	// Description is included in the output of Describe. The libp2p
+	// constructor sets it to the configuration the host was built with.
+	Description interface{}
+
+	negtimeout    time.Duration
+	rejectTTL     time.Duration
+	dialRanker    DialRanker
+	resolveLimits resolveLimits
 
 	proc goprocess.Process
 
@@ -88,7 +93,42 @@ type BasicHost struct {
 	lastAddrs []ma.Multiaddr
 	emitters  struct {
 		evtLocalProtocolsUpdated event.Emitter
+		evtLocalAddrsUpdated     event.Emitter
+		evtListenerClosed        event.Emitter
+		evtPeerIDMismatch        event.Emitter
 	}
+
+	// signaled when our addresses may have changed.
+	addrChanged chan struct{}
+
+	// addresses we're listening on, keyed by their byte representation.
+	listenMx    sync.Mutex
+	listenAddrs map[string]ma.Multiaddr
+
+	// set by Shutdown; no new inbound streams and connections are accepted
+	// once set. handlers tracks the inbound streams being handled.
+	drainMx  sync.Mutex
+	draining bool
+	handlers sync.WaitGroup
+
+	// limiters of the handlers set with SetStreamHandlerWithLimits.
+	limitsMx sync.Mutex
+	limiters map[protocol.ID]*streamLimiter
+
+	dialStatsMx sync.Mutex
+	dialStats   DialStats
+
+	// the attempts to connect to peers, shared by concurrent Connect calls.
+	connectMx sync.Mutex
+	connects  map[peer.ID]*connectCall
+
+	// serializes updates of the protocols rejected by peers.
+	rejectMx sync.Mutex
+
+	// see Use and UseFor.
+	middlewareMx    sync.Mutex
+	middleware      []StreamMiddleware
+	protoMiddleware map[protocol.ID][]StreamMiddleware
 }
 
 var _ host.Host = (*BasicHost)(nil)
@@ -104,14 +144,52 @@ type HostOpts struct {
 	// If below 0, timeouts on streams will be deactivated.
 	NegotiationTimeout time.Duration
 
+	// RejectedProtocolsTTL determines how long NewStream remembers that a peer
+	// doesn't support a protocol, failing fast with ErrProtocolNotSupported.
+	// If 0 or omitted, it will use DefaultRejectedProtocolsTTL.
+	// If below 0, rejections aren't remembered.
+	RejectedProtocolsTTL time.Duration
+
+	// DialRanker orders and staggers the dials to a peer's addresses on
+	// networks implementing AddrDialer. If omitted, it will use
+	// DefaultDialRanker. Other networks, such as the swarm, dial the peer's
+	// addresses themselves; NewHost fails if a ranker is given with one.
+	DialRanker DialRanker
+
 	// AddrsFactory holds a function which can be used to override or filter the result of Addrs.
 	// If omitted, there's no override or filtering, and the results of Addrs and AllAddrs are the same.
 	AddrsFactory AddrsFactory
 
 	// MultiaddrResolves holds the go-multiaddr-dns.Resolver used for resolving
 	// /dns4, /dns6, and /dnsaddr addresses before trying to connect to a peer.
+	// If omitted, the host resolves them with DNSResolver.
 	MultiaddrResolver *madns.Resolver
 
+	// DNSResolver is the backend resolving DNS addresses if MultiaddrResolver
+	// is omitted. Its lookups are cached by the host, in a cache of its own,
+	// unless it already is a *resolver.Cache.
+	// If omitted, it will use the system resolver, whose answers are only
+	// cached for resolver.DefaultMinTTL as it doesn't report TTLs.
+	DNSResolver resolver.Backend
+
+	// MaxAddressResolution is the maximum number of DNS resolutions performed
+	// for the addresses of a peer before connecting to it.
+	// If 0 or omitted, it will use DefaultMaxAddressResolution.
+	// If negative, the number isn't limited.
+	MaxAddressResolution int
+
+	// MaxResolveDepth is the maximum nesting of /dnsaddr records followed
+	// when resolving an address.
+	// If 0 or omitted, it will use DefaultMaxResolveDepth.
+	// If negative, the nesting isn't limited.
+	MaxResolveDepth int
+
+	// MaxResolveFanout is the maximum number of addresses used from a single
+	// resolution.
+	// If 0 or omitted, it will use DefaultMaxResolveFanout.
+	// If negative, the number isn't limited.
+	MaxResolveFanout int
+
 	// NATManager takes care of setting NAT port mappings, and discovering external addresses.
 	// If omitted, this will simply be disabled.
 	NATManager func(network.Network) NATManager
@@ -128,19 +206,43 @@ type HostOpts struct {
 
 // NewHost constructs a new *BasicHost and activates it by attaching its stream and connection handlers to the given inet.Network.
 func NewHost(ctx context.Context, net network.Network, opts *HostOpts) (*BasicHost, error) {
+	if opts.DialRanker != nil {
+		if _, ok := net.(AddrDialer); !ok {
+			return nil, fmt.Errorf("cannot rank dials; %T can't dial specific addresses", net)
+		}
+	}
+
 	h := &BasicHost{
 		network:      net,
 		mux:          msmux.NewMultistreamMuxer(),
 		negtimeout:   DefaultNegotiationTimeout,
+		rejectTTL:    DefaultRejectedProtocolsTTL,
+		dialRanker:   DefaultDialRanker,
 		AddrsFactory: DefaultAddrsFactory,
-		maResolver:   madns.DefaultResolver,
 		eventbus:     eventbus.NewBus(),
+		listenAddrs:  make(map[string]ma.Multiaddr),
+		resolveLimits: resolveLimits{
+			steps:  DefaultMaxAddressResolution,
+			depth:  DefaultMaxResolveDepth,
+			fanout: DefaultMaxResolveFanout,
+		},
 	}
 
+	h.addrChanged = make(chan struct{}, 1)
+
 	var err error
 	if h.emitters.evtLocalProtocolsUpdated, err = h.eventbus.Emitter(&event.EvtLocalProtocolsUpdated{}); err != nil {
 		return nil, err
 	}
+	if h.emitters.evtLocalAddrsUpdated, err = h.eventbus.Emitter(&event.EvtLocalAddressesUpdated{}); err != nil {
+		return nil, err
+	}
+	if h.emitters.evtListenerClosed, err = h.eventbus.Emitter(&EvtListenerClosed{}); err != nil {
+		return nil, err
+	}
+	if h.emitters.evtPeerIDMismatch, err = h.eventbus.Emitter(&EvtPeerIDMismatch{}); err != nil {
+		return nil, err
+	}
 
 	h.proc = goprocessctx.WithContextAndTeardown(ctx, func() error {
 		if h.natmgr != nil {
@@ -150,9 +252,20 @@ func NewHost(ctx context.Context, net network.Network, opts *HostOpts) (*BasicHo
 			h.cmgr.Close()
 		}
 		_ = h.emitters.evtLocalProtocolsUpdated.Close()
+		_ = h.emitters.evtLocalAddrsUpdated.Close()
+		_ = h.emitters.evtListenerClosed.Close()
+		_ = h.emitters.evtPeerIDMismatch.Close()
 		return h.Network().Close()
 	})
 
+	protoSub, err := h.eventbus.Subscribe(&event.EvtPeerProtocolsUpdated{}, eventbus.BufSize(16))
+	if err != nil {
+		return nil, err
+	}
+	h.proc.Go(func(p goprocess.Process) {
+		h.watchAdvertisedProtocols(p, protoSub)
+	})
+
 	if opts.MultistreamMuxer != nil {
 		h.mux = opts.MultistreamMuxer
 	}
@@ -168,6 +281,14 @@ func NewHost(ctx context.Context, net network.Network, opts *HostOpts) (*BasicHo
 		h.negtimeout = opts.NegotiationTimeout
 	}
 
+	if opts.RejectedProtocolsTTL != 0 {
+		h.rejectTTL = opts.RejectedProtocolsTTL
+	}
+
+	if opts.DialRanker != nil {
+		h.dialRanker = opts.DialRanker
+	}
+
 	if opts.AddrsFactory != nil {
 		h.AddrsFactory = opts.AddrsFactory
 	}
@@ -178,6 +299,20 @@ func NewHost(ctx context.Context, net network.Network, opts *HostOpts) (*BasicHo
 
 	if opts.MultiaddrResolver != nil {
 		h.maResolver = opts.MultiaddrResolver
+	} else {
+		h.maResolver = newMultiaddrResolver(opts.DNSResolver)
+	}
+
+	if opts.MaxAddressResolution != 0 {
+		h.resolveLimits.steps = opts.MaxAddressResolution
+	}
+
+	if opts.MaxResolveDepth != 0 {
+		h.resolveLimits.depth = opts.MaxResolveDepth
+	}
+
+	if opts.MaxResolveFanout != 0 {
+		h.resolveLimits.fanout = opts.MaxResolveFanout
 	}
 
 	if opts.ConnManager == nil {
@@ -193,6 +328,8 @@ func NewHost(ctx context.Context, net network.Network, opts *HostOpts) (*BasicHo
 
 	net.SetConnHandler(h.newConnHandler)
 	net.SetStreamHandler(h.newStreamHandler)
+	net.Notify(h.listenNotifiee())
+	net.Notify(h.drainNotifiee())
 
 	return h, nil
 }
@@ -236,6 +373,13 @@ func New(net network.Network, opts ...interface{}) *BasicHost {
 
 // Start starts background tasks in the host
 func (h *BasicHost) Start() {
+	// initialize lastAddrs, the changes made from now on are announced.
+	h.mx.Lock()
+	if h.lastAddrs == nil {
+		h.lastAddrs = h.Addrs()
+	}
+	h.mx.Unlock()
+
 	h.proc.Go(h.background)
 }
 
@@ -244,12 +388,26 @@ func (h *BasicHost) newConnHandler(c network.Conn) {
 	// Clear protocols on connecting to new peer to avoid issues caused
 	// by misremembering protocols between reconnects
 	h.Peerstore().SetProtocols(c.RemotePeer())
+	h.clearRejectedProtocols(c.RemotePeer())
 	h.ids.IdentifyConn(c)
 }
 
 // newStreamHandler is the remote-opened stream handler for network.Network
 // TODO: this feels a bit wonky
 func (h *BasicHost) newStreamHandler(s network.Stream) {
+	// Refuse new streams when shutting down and keep track of the active
+	// ones so Shutdown can wait for them.
+	if !h.startHandler() {
+		s.Reset()
+		return
+	}
+	handling := false
+	defer func() {
+		if !handling {
+			h.handlers.Done()
+		}
+	}()
+
 	before := time.Now()
 
 	if h.negtimeout > 0 {
@@ -292,44 +450,45 @@ func (h *BasicHost) newStreamHandler(s network.Stream) {
 	s.SetProtocol(protocol.ID(protoID))
 	log.Debugf("protocol negotiation took %s", took)
 
-	go handle(protoID, s)
+	handling = true
+	go func() {
+		defer h.handlers.Done()
+		handle(protoID, s)
+	}()
 }
 
-// PushIdentify pushes an identify update through the identify push protocol
+// PushIdentify checks our addresses for changes right away. The changes are
+// announced with an EvtLocalAddressesUpdated, which identify pushes to our
+// peers.
 // Warning: this interface is unstable and may disappear in the future.
+//
+// Deprecated: Address changes are pushed automatically; use
+// SignalAddressChange if the AddrsFactory returns new addresses.
 func (h *BasicHost) PushIdentify() {
-	push := false
-
-	h.mx.Lock()
-	addrs := h.Addrs()
-	if !sameAddrs(addrs, h.lastAddrs) {
-		push = true
-		h.lastAddrs = addrs
-	}
-	h.mx.Unlock()
-
-	if push {
-		h.ids.Push()
-	}
+	h.updateAddrs()
 }
 
 func (h *BasicHost) background(p goprocess.Process) {
-	// periodically schedules an IdentifyPush to update our peers for changes
-	// in our address set (if needed)
-	ticker := time.NewTicker(1 * time.Minute)
+	// periodically checks our address set for changes, so they're announced
+	// even when we're not told about them.
+	ticker := time.NewTicker(addrCheckInterval)
 	defer ticker.Stop()
 
-	// initialize lastAddrs
-	h.mx.Lock()
-	if h.lastAddrs == nil {
-		h.lastAddrs = h.Addrs()
+	// track listeners opened before we started watching.
+	h.listenMx.Lock()
+	for _, a := range h.Network().ListenAddresses() {
+		h.listenAddrs[string(a.Bytes())] = a
 	}
-	h.mx.Unlock()
+	h.listenMx.Unlock()
 
 	for {
 		select {
 		case <-ticker.C:
-			h.PushIdentify()
+			h.checkListeners()
+			h.updateAddrs()
+
+		case <-h.addrChanged:
+			h.updateAddrs()
 
 		case <-p.Closing():
 			return
@@ -337,24 +496,28 @@ func (h *BasicHost) background(p goprocess.Process) {
 	}
 }
 
-func sameAddrs(a, b []ma.Multiaddr) bool {
-	if len(a) != len(b) {
-		return false
+// diffAddrs returns the addresses added to and removed from the old set.
+func diffAddrs(old, new []ma.Multiaddr) (added, removed []ma.Multiaddr) {
+	oldmap := make(map[string]struct{}, len(old))
+	for _, addr := range old {
+		oldmap[string(addr.Bytes())] = struct{}{}
 	}
 
-	bmap := make(map[string]struct{}, len(b))
-	for _, addr := range b {
-		bmap[string(addr.Bytes())] = struct{}{}
+	newmap := make(map[string]struct{}, len(new))
+	for _, addr := range new {
+		newmap[string(addr.Bytes())] = struct{}{}
+		if _, ok := oldmap[string(addr.Bytes())]; !ok {
+			added = append(added, addr)
+		}
 	}
 
-	for _, addr := range a {
-		_, ok := bmap[string(addr.Bytes())]
-		if !ok {
-			return false
+	for _, addr := range old {
+		if _, ok := newmap[string(addr.Bytes())]; !ok {
+			removed = append(removed, addr)
 		}
 	}
 
-	return true
+	return added, removed
 }
 
 // ID returns the (local) peer.ID associated with this Host
@@ -386,15 +549,24 @@ func (h *BasicHost) EventBus() event.Bus {
 	return h.eventbus
 }
 
+// Process returns the host's process. Children added to it are closed before
+// the host's network shuts down.
+func (h *BasicHost) Process() goprocess.Process {
+	return h.proc
+}
+
 // SetStreamHandler sets the protocol handler on the Host's Mux.
 // This is equivalent to:
 //   host.Mux().SetHandler(proto, handler)
 // (Threadsafe)
+//
+// The handler is wrapped with the middleware registered with Use and UseFor.
 func (h *BasicHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.removeStreamLimiter(pid)
 	h.Mux().AddHandler(string(pid), func(p string, rwc io.ReadWriteCloser) error {
 		is := rwc.(network.Stream)
 		is.SetProtocol(protocol.ID(p))
-		handler(is)
+		h.wrapHandler(pid, handler)(is)
 		return nil
 	})
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
@@ -405,10 +577,11 @@ func (h *BasicHost) SetStreamHandler(pid protocol.ID, handler network.StreamHand
 // SetStreamHandlerMatch sets the protocol handler on the Host's Mux
 // using a matching function to do protocol comparisons
 func (h *BasicHost) SetStreamHandlerMatch(pid protocol.ID, m func(string) bool, handler network.StreamHandler) {
+	h.removeStreamLimiter(pid)
 	h.Mux().AddHandlerWithFunc(string(pid), m, func(p string, rwc io.ReadWriteCloser) error {
 		is := rwc.(network.Stream)
 		is.SetProtocol(protocol.ID(p))
-		handler(is)
+		h.wrapHandler(pid, handler)(is)
 		return nil
 	})
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
@@ -418,6 +591,7 @@ func (h *BasicHost) SetStreamHandlerMatch(pid protocol.ID, m func(string) bool,
 
 // RemoveStreamHandler returns ..
 func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
+	h.removeStreamLimiter(pid)
 	h.Mux().RemoveHandler(string(pid))
 	h.emitters.evtLocalProtocolsUpdated.Emit(event.EvtLocalProtocolsUpdated{
 		Removed: []protocol.ID{pid},
@@ -427,20 +601,42 @@ func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
 // NewStream opens a new stream to given peer p, and writes a p2p/protocol
 // header with given protocol.ID. If there is no connection to p, attempts
 // to create one. If ProtocolID is "", writes no header.
+//
+// The first protocol the peer is known to support is preferred, unless the
+// context was created with WithHighestVersion. The preferred protocol is
+// negotiated lazily unless the context was created with WithEagerNegotiation.
+// In that case, protocol negotiation failures are returned as
+// *NegotiationError; otherwise the multistream error is returned as is.
+//
+// Protocols the peer rejected are remembered until it reconnects or advertises
+// them (see HostOpts.RejectedProtocolsTTL), even if the peerstore still lists
+// them, and NewStream fails with ErrProtocolNotSupported if the peer rejected
+// all the given protocols.
 // (Threadsafe)
 func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if getHighestVersion(ctx) {
+		pids = sortByVersion(pids)
+	}
+
+	if len(pids) > 0 {
+		if pids = h.withoutRejected(p, pids); len(pids) == 0 {
+			return nil, ErrProtocolNotSupported
+		}
+	}
+
 	pref, err := h.preferredProtocol(p, pids)
 	if err != nil {
 		return nil, err
 	}
 
+	eager, timeout := getEagerNegotiation(ctx)
 	if pref != "" {
-		return h.newStream(ctx, p, pref)
-	}
-
-	var protoStrs []string
-	for _, pid := range pids {
-		protoStrs = append(protoStrs, string(pid))
+		if !eager {
+			return h.newStream(ctx, p, pref)
+		}
+		// Try the preferred protocol first, falling back to the others if
+		// the peerstore is out of date.
+		pids = append([]protocol.ID{pref}, removeProtocol(pids, pref)...)
 	}
 
 	s, err := h.Network().NewStream(ctx, p)
@@ -448,18 +644,35 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 		return nil, err
 	}
 
-	selected, err := msmux.SelectOneOf(protoStrs, s)
+	selected, err := negotiate(ctx, s, timeout, pids)
 	if err != nil {
-		s.Reset()
+		nerr := err.(*NegotiationError)
+		if nerr.Failure == NegotiationNotSupported {
+			h.rejectProtocols(p, pids)
+		}
+		if !eager {
+			// keep returning the multistream errors to callers that
+			// didn't opt in.
+			return nil, nerr.Err
+		}
 		return nil, err
 	}
-	selpid := protocol.ID(selected)
-	s.SetProtocol(selpid)
-	h.Peerstore().AddProtocols(p, selected)
+	s.SetProtocol(selected)
+	h.Peerstore().AddProtocols(p, string(selected))
 
 	return s, nil
 }
 
+func removeProtocol(pids []protocol.ID, pid protocol.ID) []protocol.ID {
+	out := make([]protocol.ID, 0, len(pids))
+	for _, p := range pids {
+		if p != pid {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func pidsToStrings(pids []protocol.ID) []string {
 	out := make([]string, len(pids))
 	for i, p := range pids {
@@ -502,6 +715,10 @@ func (h *BasicHost) newStream(ctx context.Context, p peer.ID, pid protocol.ID) (
 // h.Network.Dial, and block until a connection is open, or an error is returned.
 // Connect will absorb the addresses in pi into its internal peerstore.
 // It will also resolve any /dns4, /dns6, and /dnsaddr addresses.
+//
+// Concurrent calls for the same peer share a single attempt to resolve, dial
+// and identify it, and its result. Canceling the context of a call only
+// aborts the attempt if no other call is waiting for it.
 func (h *BasicHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
 	// absorb addresses into peerstore
 	h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
@@ -510,82 +727,22 @@ func (h *BasicHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
 		return nil
 	}
 
-	resolved, err := h.resolveAddrs(ctx, h.Peerstore().PeerInfo(pi.ID))
-	if err != nil {
-		return err
-	}
-	h.Peerstore().AddAddrs(pi.ID, resolved, peerstore.TempAddrTTL)
-
-	return h.dialPeer(ctx, pi.ID)
-}
-
-func (h *BasicHost) resolveAddrs(ctx context.Context, pi peer.AddrInfo) ([]ma.Multiaddr, error) {
-	proto := ma.ProtocolWithCode(ma.P_P2P).Name
-	p2paddr, err := ma.NewMultiaddr("/" + proto + "/" + pi.ID.Pretty())
-	if err != nil {
-		return nil, err
-	}
-
-	resolveSteps := 0
-
-	// Recursively resolve all addrs.
-	//
-	// While the toResolve list is non-empty:
-	// * Pop an address off.
-	// * If the address is fully resolved, add it to the resolved list.
-	// * Otherwise, resolve it and add the results to the "to resolve" list.
-	toResolve := append(([]ma.Multiaddr)(nil), pi.Addrs...)
-	resolved := make([]ma.Multiaddr, 0, len(pi.Addrs))
-	for len(toResolve) > 0 {
-		// pop the last addr off.
-		addr := toResolve[len(toResolve)-1]
-		toResolve = toResolve[:len(toResolve)-1]
-
-		// if it's resolved, add it to the resolved list.
-		if !madns.Matches(addr) {
-			resolved = append(resolved, addr)
-			continue
-		}
-
-		resolveSteps++
-
-		// We've resolved too many addresses. We can keep all the fully
-		// resolved addresses but we'll need to skip the rest.
-		if resolveSteps >= maxAddressResolution {
-			log.Warningf(
-				"peer %s asked us to resolve too many addresses: %s/%s",
-				pi.ID,
-				resolveSteps,
-				maxAddressResolution,
-			)
-			continue
-		}
-
-		// otherwise, resolve it
-		reqaddr := addr.Encapsulate(p2paddr)
-		resaddrs, err := h.maResolver.Resolve(ctx, reqaddr)
-		if err != nil {
-			log.Infof("error resolving %s: %s", reqaddr, err)
-		}
-
-		// add the results to the toResolve list.
-		for _, res := range resaddrs {
-			pi, err := peer.AddrInfoFromP2pAddr(res)
-			if err != nil {
-				log.Infof("error parsing %s: %s", res, err)
-			}
-			toResolve = append(toResolve, pi.Addrs...)
-		}
+	c := h.joinConnect(ctx, pi.ID)
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		h.leaveConnect(pi.ID, c)
+		return ctx.Err()
 	}
-
-	return resolved, nil
 }
 
 // dialPeer opens a connection to peer, and makes sure to identify
 // the connection once it has been opened.
 func (h *BasicHost) dialPeer(ctx context.Context, p peer.ID) error {
 	log.Debugf("host %s dialing %s", h.ID(), p)
-	c, err := h.Network().DialPeer(ctx, p)
+	c, err := h.dial(ctx, p)
+	h.recordDial(c, err)
 	if err != nil {
 		return err
 	}
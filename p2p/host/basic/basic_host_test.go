@@ -3,9 +3,12 @@ package basichost
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,6 +24,7 @@ import (
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	ma "github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
+	msmux "github.com/multiformats/go-multistream"
 )
 
 func TestHostDoubleClose(t *testing.T) {
@@ -514,3 +518,1138 @@ func (sma sortedMultiaddrs) Swap(i, j int) { sma[i], sma[j] = sma[j], sma[i] }
 func (sma sortedMultiaddrs) Less(i, j int) bool {
 	return bytes.Compare(sma[i].Bytes(), sma[j].Bytes()) == 1
 }
+
+type fakeListenNetwork struct {
+	network.Network
+	mx        sync.Mutex
+	addrs     []ma.Multiaddr
+	notifiees []network.Notifiee
+}
+
+func (n *fakeListenNetwork) Notify(f network.Notifiee) {
+	n.mx.Lock()
+	n.notifiees = append(n.notifiees, f)
+	n.mx.Unlock()
+	n.Network.Notify(f)
+}
+
+func (n *fakeListenNetwork) listenClose(a ma.Multiaddr) {
+	n.mx.Lock()
+	notifiees := n.notifiees
+	n.mx.Unlock()
+	for _, f := range notifiees {
+		f.ListenClose(n, a)
+	}
+}
+
+func (n *fakeListenNetwork) ListenAddresses() []ma.Multiaddr {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.addrs
+}
+
+func TestListenCloseNotification(t *testing.T) {
+	ctx := context.Background()
+	swrm := swarmt.GenSwarm(t, ctx)
+	n := &fakeListenNetwork{Network: swrm, addrs: swrm.ListenAddresses()}
+	h, err := NewHost(ctx, n, &HostOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.Start()
+
+	sub, err := h.EventBus().Subscribe(&EvtListenerClosed{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	listening := n.ListenAddresses()
+	if len(listening) == 0 {
+		t.Fatal("expected the swarm to listen")
+	}
+	for _, a := range listening {
+		h.listenMx.Lock()
+		h.listenAddrs[string(a.Bytes())] = a
+		h.listenMx.Unlock()
+	}
+
+	n.listenClose(listening[0])
+	select {
+	case evt := <-sub.Out():
+		if addr := evt.(EvtListenerClosed).Addr; !addr.Equal(listening[0]) {
+			t.Errorf("expected listener on %s to close, got %s", listening[0], addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a listener closed event")
+	}
+}
+
+func TestListenerClosedEvent(t *testing.T) {
+	ctx := context.Background()
+	swrm := swarmt.GenSwarm(t, ctx)
+	n := &fakeListenNetwork{Network: swrm, addrs: swrm.ListenAddresses()}
+	h, err := NewHost(ctx, n, &HostOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe(&EvtListenerClosed{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	listening := n.ListenAddresses()
+	if len(listening) == 0 {
+		t.Fatal("expected the swarm to listen")
+	}
+	for _, a := range listening {
+		h.listenAddrs[string(a.Bytes())] = a
+	}
+
+	// nothing changed.
+	h.checkListeners()
+
+	n.mx.Lock()
+	n.addrs = listening[1:]
+	n.mx.Unlock()
+	h.checkListeners()
+
+	select {
+	case evt := <-sub.Out():
+		if addr := evt.(EvtListenerClosed).Addr; !addr.Equal(listening[0]) {
+			t.Errorf("expected listener on %s to close, got %s", listening[0], addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a listener closed event")
+	}
+
+	select {
+	case evt := <-sub.Out():
+		t.Fatalf("unexpected event: %v", evt)
+	default:
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := New(swarmt.GenSwarm(t, ctx))
+	h2 := New(swarmt.GenSwarm(t, ctx))
+	defer h1.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h2.SetStreamHandler("/test", func(s network.Stream) {
+		close(started)
+		<-release
+		s.Close()
+	})
+
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// force protocol negotiation.
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		done <- h2.Shutdown(sctx)
+	}()
+
+	// peers should be told we no longer support our protocols.
+	for i := 0; ; i++ {
+		protos, err := h1.Peerstore().SupportsProtocols(h2.ID(), "/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(protos) == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("expected the protocol removal to be announced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// new streams should be refused.
+	s2, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err == nil {
+		if _, err := s2.Read(make([]byte, 1)); err == nil {
+			t.Error("expected new streams to be refused")
+		}
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected shutdown to wait for the active handler, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't return")
+	}
+	if len(h2.Network().Conns()) != 0 {
+		t.Error("expected the network to be closed")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+
+	started := make(chan struct{})
+	h2.SetStreamHandler("/test", func(s network.Stream) {
+		close(started)
+		// never returns on its own.
+		io.Copy(ioutil.Discard, s)
+	})
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	sctx, scancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer scancel()
+	if err := h2.(*BasicHost).Shutdown(sctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected a deadline exceeded error, got %v", err)
+	}
+	if _, err := s.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the stream to be reset")
+	}
+}
+
+func TestStreamLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+	bh2 := h2.(*BasicHost)
+
+	for _, policy := range []LimitPolicy{LimitReset, LimitWait} {
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		bh2.SetStreamHandlerWithLimits("/test", StreamLimits{MaxStreamsPerPeer: 1, Policy: policy}, func(s network.Stream) {
+			started <- struct{}{}
+			<-release
+			s.Write([]byte("ok"))
+			s.Close()
+		})
+
+		var streams []network.Stream
+		for i := 0; i < 2; i++ {
+			s, err := h1.NewStream(ctx, h2.ID(), "/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			streams = append(streams, s)
+		}
+		<-started
+
+		// wait for the second stream to hit the limit.
+		for i := 0; ; i++ {
+			stats, ok := bh2.StreamLimitStats("/test")
+			if !ok {
+				t.Fatal("expected stream limit stats")
+			}
+			if stats.Rejected+stats.Queued == 1 {
+				break
+			}
+			if i > 100 {
+				t.Fatalf("expected the second stream to hit the limit, got %+v", stats)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(release)
+
+		if _, err := ioutil.ReadAll(streams[0]); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ioutil.ReadAll(streams[1])
+		switch policy {
+		case LimitReset:
+			if err == nil {
+				t.Error("expected the second stream to be reset")
+			}
+		case LimitWait:
+			if err != nil {
+				t.Errorf("expected the second stream to be handled, got %s", err)
+			}
+		}
+
+		// handlers release their slot after the stream closes.
+		var stats StreamLimitStats
+		for i := 0; i < 100; i++ {
+			stats, _ = bh2.StreamLimitStats("/test")
+			if stats.Active == 0 && stats.Waiting == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stats.Active != 0 || stats.Waiting != 0 {
+			t.Errorf("expected no active or waiting streams, got %+v", stats)
+		}
+		if policy == LimitReset && (stats.Accepted != 1 || stats.Rejected != 1) {
+			t.Errorf("expected one accepted and one rejected stream, got %+v", stats)
+		}
+		if policy == LimitWait && (stats.Accepted != 2 || stats.Queued != 1) {
+			t.Errorf("expected two accepted streams, one of them queued, got %+v", stats)
+		}
+	}
+
+	bh2.RemoveStreamHandler("/test")
+	if _, ok := bh2.StreamLimitStats("/test"); ok {
+		t.Error("expected the limiter to be removed with the handler")
+	}
+}
+
+func TestStreamMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+	bh2 := h2.(*BasicHost)
+
+	var mx sync.Mutex
+	var calls []string
+	record := func(name string) StreamMiddleware {
+		return func(next network.StreamHandler) network.StreamHandler {
+			return func(s network.Stream) {
+				mx.Lock()
+				calls = append(calls, name)
+				mx.Unlock()
+				next(s)
+			}
+		}
+	}
+
+	done := make(chan struct{}, 1)
+	h2.SetStreamHandler("/test", func(s network.Stream) {
+		record("handler")(func(network.Stream) {})(s)
+		s.Write([]byte("ok"))
+		s.Close()
+		done <- struct{}{}
+	})
+	// middleware applies to handlers set before it's registered.
+	bh2.Use(record("global1"), record("global2"))
+	bh2.UseFor("/test", record("proto"))
+	bh2.UseFor("/other", record("other"))
+
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	mx.Lock()
+	expected := []string{"global1", "global2", "proto", "handler"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	mx.Unlock()
+}
+
+func TestPanicRecovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	h2.(*BasicHost).Use(PanicRecovery)
+	h2.SetStreamHandler("/test", func(s network.Stream) {
+		panic("boom")
+	})
+
+	// depending on timing, the reset surfaces when negotiating, writing or
+	// reading.
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err == nil {
+		if _, err = s.Write([]byte("hello")); err == nil {
+			_, err = ioutil.ReadAll(s)
+		}
+	}
+	if err == nil {
+		t.Error("expected the stream to be reset")
+	}
+}
+
+func TestSemverMatcher(t *testing.T) {
+	m, err := SemverMatcher("/testing/1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for proto, match := range map[string]bool{
+		"/testing/1.0.0":     true,
+		"/testing/1.2.0":     true,
+		"/testing/1.2.7":     true,
+		"/testing/1.3.0":     false,
+		"/testing/2.0.0":     false,
+		"/testing/0.9.0":     false,
+		"/testing":           false,
+		"/testing/foo":       false,
+		"/other/1.2.0":       false,
+		"/testing/sub/1.2.0": false,
+	} {
+		if m(proto) != match {
+			t.Errorf("expected match(%s) to be %t", proto, match)
+		}
+	}
+
+	m, err = SemverMatcher("/testing/0.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m("/testing/0.2.3") || m("/testing/0.1.0") {
+		t.Error("expected unstable versions to only match the same minor version")
+	}
+
+	if _, err := SemverMatcher("/testing"); err == nil {
+		t.Error("expected a protocol without a version to fail")
+	}
+}
+
+func TestSortByVersion(t *testing.T) {
+	sorted := sortByVersion([]protocol.ID{"/testing", "/testing/1.0.0", "/testing/1.10.0", "/testing/1.2.0"})
+	expected := []protocol.ID{"/testing/1.10.0", "/testing/1.2.0", "/testing/1.0.0", "/testing"}
+	for i := range expected {
+		if sorted[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, sorted)
+		}
+	}
+}
+
+func TestNewStreamHighestVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	protoOld := protocol.ID("/testing/1.0.0")
+	protoNew := protocol.ID("/testing/1.2.0")
+	protoNewer := protocol.ID("/testing/1.3.0")
+
+	connectedOn := make(chan protocol.ID)
+	handler := func(s network.Stream) {
+		connectedOn <- s.Protocol()
+		s.Close()
+	}
+	h1.SetStreamHandler(protoOld, handler)
+	if err := h1.(*BasicHost).SetStreamHandlerSemver(protoNew, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the protocols to be pushed
+	for i := 0; ; i++ {
+		supported, err := h2.Peerstore().SupportsProtocols(h1.ID(), string(protoOld), string(protoNew))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(supported) == 2 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("timed out waiting for the protocols to be pushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tc := range []struct {
+		ctx      context.Context
+		expected protocol.ID
+	}{
+		{ctx, protoOld},
+		{WithHighestVersion(ctx), protoNew},
+	} {
+		s, err := h2.NewStream(tc.ctx, h1.ID(), protoOld, protoNew, protoNewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// required to force 'lazy' handshake
+		if _, err := s.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		assertWait(t, connectedOn, tc.expected)
+		s.Close()
+	}
+}
+
+func TestNewStreamEagerNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	h1.SetStreamHandler("/test", func(s network.Stream) {
+		// writing waits for the negotiation response to be sent
+		s.Write([]byte("ok"))
+		s.Close()
+	})
+
+	nctx := WithEagerNegotiation(ctx, time.Second)
+	s, err := h2.NewStream(nctx, h1.ID(), "/other", "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Protocol() != "/test" {
+		t.Errorf("expected protocol /test, got %s", s.Protocol())
+	}
+	s.Reset()
+
+	// rejected protocols are remembered, so every check uses new ones.
+	checkFailure := func(ctx context.Context, expected NegotiationFailure, pids ...protocol.ID) {
+		t.Helper()
+		_, err := h2.NewStream(ctx, h1.ID(), pids...)
+		nerr, ok := err.(*NegotiationError)
+		if !ok {
+			t.Fatalf("expected a negotiation error, got %v", err)
+		}
+		if nerr.Failure != expected {
+			t.Errorf("expected failure %q, got %q", expected, nerr.Failure)
+		}
+		if nerr.Peer != h1.ID() || len(nerr.Protocols) != len(pids) {
+			t.Errorf("unexpected peer or protocols in %s", nerr)
+		}
+	}
+
+	h1.RemoveStreamHandler("/test")
+	h2.Peerstore().SetProtocols(h1.ID())
+	checkFailure(nctx, NegotiationNotSupported, "/test", "/other")
+
+	h1.Network().SetStreamHandler(func(s network.Stream) {
+		s.Reset()
+	})
+	checkFailure(nctx, NegotiationReset, "/reset")
+
+	// never respond
+	h1.Network().SetStreamHandler(func(s network.Stream) {
+		io.Copy(ioutil.Discard, s)
+	})
+	checkFailure(WithEagerNegotiation(ctx, 100*time.Millisecond), NegotiationTimedOut, "/timeout")
+
+	cctx, ccancel := context.WithCancel(nctx)
+	time.AfterFunc(100*time.Millisecond, ccancel)
+	checkFailure(cctx, NegotiationCanceled, "/cancel")
+}
+
+func TestRejectedProtocols(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	// callers that don't opt in to eager negotiation get the multistream
+	// error.
+	_, err := h2.NewStream(ctx, h1.ID(), "/a", "/b")
+	if err != msmux.ErrNotSupported {
+		t.Fatalf("expected the protocols not to be supported, got %v", err)
+	}
+	for _, pids := range [][]protocol.ID{{"/a", "/b"}, {"/b"}} {
+		if _, err := h2.NewStream(ctx, h1.ID(), pids...); err != ErrProtocolNotSupported {
+			t.Fatalf("expected %v to fail fast, got %v", pids, err)
+		}
+	}
+	// only rejected protocols are skipped
+	nctx := WithEagerNegotiation(ctx, time.Second)
+	_, err = h2.NewStream(nctx, h1.ID(), "/a", "/c")
+	if nerr, ok := err.(*NegotiationError); !ok || !reflect.DeepEqual(nerr.Protocols, []protocol.ID{"/c"}) {
+		t.Fatalf("expected only /c to be negotiated, got %v", err)
+	}
+	if !errors.Is(err, msmux.ErrNotSupported) {
+		t.Fatalf("expected the error to wrap the multistream error, got %v", err)
+	}
+
+	// rejections take precedence over protocols the peerstore still lists
+	h2.Peerstore().AddProtocols(h1.ID(), "/d")
+	if _, err := h2.NewStream(nctx, h1.ID(), "/d"); err == nil {
+		t.Fatal("expected /d not to be supported")
+	}
+	if _, err := h2.NewStream(ctx, h1.ID(), "/d"); err != ErrProtocolNotSupported {
+		t.Fatalf("expected the rejected protocol to fail fast, got %v", err)
+	}
+
+	// advertised protocols override rejections
+	h1.SetStreamHandler("/a", func(s network.Stream) {
+		s.Write([]byte("ok"))
+		s.Close()
+	})
+	for i := 0; ; i++ {
+		s, err := h2.NewStream(ctx, h1.ID(), "/a")
+		if err == nil {
+			s.Reset()
+			break
+		}
+		if i > 100 {
+			t.Fatal("expected the advertised protocol to be used")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// reconnecting clears the rejections
+	h2.(*BasicHost).rejectProtocols(h1.ID(), []protocol.ID{"/b"})
+	h2.Network().ClosePeer(h1.ID())
+	if err := h2.Connect(ctx, h1.Peerstore().PeerInfo(h1.ID())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h2.NewStream(ctx, h1.ID(), "/b"); err == ErrProtocolNotSupported {
+		t.Error("expected the rejections to be cleared on reconnect")
+	}
+}
+
+func TestDefaultDialRanker(t *testing.T) {
+	private := ma.StringCast("/ip4/192.168.1.2/tcp/1")
+	ip6 := ma.StringCast("/ip6/2001:db8::1/tcp/1")
+	ip4 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	dns := ma.StringCast("/dns4/example.com/tcp/1")
+	relay := ma.StringCast("/ip4/1.2.3.5/tcp/1/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit")
+
+	ranked := DefaultDialRanker([]ma.Multiaddr{relay, dns, ip4, ip6, private})
+	expected := []AddrDelay{
+		{private, 0},
+		{ip6, PublicDialDelay},
+		{ip4, PublicDialDelay + IPv4DialDelay},
+		{dns, PublicDialDelay + IPv4DialDelay},
+		{relay, PublicDialDelay + IPv4DialDelay + RelayDialDelay},
+	}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Fatalf("expected %v, got %v", expected, ranked)
+	}
+
+	// no delay before the first class present
+	ranked = DefaultDialRanker([]ma.Multiaddr{relay, ip4})
+	expected = []AddrDelay{{ip4, 0}, {relay, RelayDialDelay}}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Fatalf("expected %v, got %v", expected, ranked)
+	}
+}
+
+type fakeConn struct {
+	network.Conn
+	addr ma.Multiaddr
+}
+
+func (c *fakeConn) RemoteMultiaddr() ma.Multiaddr { return c.addr }
+
+func TestDialRanked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	a2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	a3 := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	ranked := []AddrDelay{{a1, 0}, {a2, 200 * time.Millisecond}, {a3, 10 * time.Second}}
+
+	var mx sync.Mutex
+	var dialed []ma.Multiaddr
+	dial := func(fail, block map[ma.Multiaddr]bool) func(context.Context, ma.Multiaddr) (network.Conn, error) {
+		return func(ctx context.Context, a ma.Multiaddr) (network.Conn, error) {
+			mx.Lock()
+			dialed = append(dialed, a)
+			mx.Unlock()
+			if block[a] {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			if fail[a] {
+				return nil, errors.New("unreachable")
+			}
+			return &fakeConn{addr: a}, nil
+		}
+	}
+
+	// a slow dial doesn't prevent the next one once it's due
+	start := time.Now()
+	c, err := dialRanked(ctx, ranked, dial(nil, map[ma.Multiaddr]bool{a1: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteMultiaddr() != a2 {
+		t.Errorf("expected %s to win, got %s", a2, c.RemoteMultiaddr())
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("expected the second dial to be delayed, took %s", d)
+	}
+
+	// failed dials don't wait for the delay of the next one
+	dialed = nil
+	start = time.Now()
+	c, err = dialRanked(ctx, ranked, dial(map[ma.Multiaddr]bool{a1: true, a2: true}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteMultiaddr() != a3 {
+		t.Errorf("expected %s to win, got %s", a3, c.RemoteMultiaddr())
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the dials to fast-forward, took %s", d)
+	}
+	if !reflect.DeepEqual(dialed, []ma.Multiaddr{a1, a2, a3}) {
+		t.Errorf("unexpected dial order %v", dialed)
+	}
+
+	_, err = dialRanked(ctx, ranked, dial(map[ma.Multiaddr]bool{a1: true, a2: true, a3: true}, nil))
+	if err == nil {
+		t.Error("expected all dials to fail")
+	}
+}
+
+func TestDialStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	stats := h1.(*BasicHost).DialStats()
+	if stats.Wins[AddrClassPrivate] != 1 || stats.Failures != 0 {
+		t.Errorf("expected 1 private win, got %v", stats)
+	}
+
+	p, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: p}); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if stats := h1.(*BasicHost).DialStats(); stats.Failures != 1 {
+		t.Errorf("expected 1 failure, got %v", stats)
+	}
+}
+
+func TestDialRankerSwarm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the swarm dials all the addresses of a peer itself
+	swrm := swarmt.GenSwarm(t, ctx)
+	defer swrm.Close()
+	_, err := NewHost(ctx, swrm, &HostOpts{DialRanker: NoDelayDialRanker})
+	if err == nil {
+		t.Fatal("expected configuring a dial ranker on a swarm to fail")
+	}
+
+	// the default ranker is ignored
+	h1 := New(swarmt.GenSwarm(t, ctx))
+	defer h1.Close()
+	h2 := New(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	if stats := h1.DialStats(); stats.Wins[AddrClassPrivate] != 1 {
+		t.Errorf("expected the dial to be counted, got %v", stats)
+	}
+}
+
+func TestAddrResolutionMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p1, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &madns.MockBackend{
+		TXT: map[string][]string{
+			"_dnsaddr.example.com": []string{
+				"dnsaddr=/ip4/192.0.2.1/tcp/123/p2p/" + p2.Pretty(),
+			},
+		},
+	}
+	h := New(swarmt.GenSwarm(t, ctx), &madns.Resolver{Backend: backend})
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe(new(EvtPeerIDMismatch), eventbus.BufSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	addr := ma.StringCast("/dnsaddr/example.com")
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+	_ = h.Connect(tctx, peer.AddrInfo{ID: p1, Addrs: []ma.Multiaddr{addr}})
+
+	if addrs := h.Peerstore().Addrs(p1); len(addrs) != 1 {
+		t.Errorf("expected the other peer's address to be dropped, got %v", addrs)
+	}
+	select {
+	case e := <-sub.Out():
+		evt := e.(EvtPeerIDMismatch)
+		if evt.Peer != p1 || !evt.Addr.Equal(addr) || !reflect.DeepEqual(evt.Found, []peer.ID{p2}) {
+			t.Errorf("unexpected event %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a mismatch event")
+	}
+}
+
+func TestAddrResolutionLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &madns.MockBackend{
+		TXT: map[string][]string{
+			"_dnsaddr.example.com": []string{
+				"dnsaddr=/dnsaddr/foo.example.com/p2p/" + p.Pretty(),
+				"dnsaddr=/ip4/192.0.2.1/tcp/1/p2p/" + p.Pretty(),
+				"dnsaddr=/ip4/192.0.2.1/tcp/2/p2p/" + p.Pretty(),
+			},
+			"_dnsaddr.foo.example.com": []string{
+				"dnsaddr=/ip4/192.0.2.1/tcp/3/p2p/" + p.Pretty(),
+			},
+		},
+	}
+
+	resolve := func(opts *HostOpts) []ma.Multiaddr {
+		opts.MultiaddrResolver = &madns.Resolver{Backend: backend}
+		h, err := NewHost(ctx, swarmt.GenSwarm(t, ctx), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		addrs, err := h.resolveAddrs(ctx, peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/dnsaddr/example.com")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addrs
+	}
+
+	if addrs := resolve(&HostOpts{}); len(addrs) != 3 {
+		t.Errorf("expected 3 addresses, got %v", addrs)
+	}
+	// foo.example.com isn't resolved
+	if addrs := resolve(&HostOpts{MaxResolveDepth: 1}); len(addrs) != 2 {
+		t.Errorf("expected 2 addresses, got %v", addrs)
+	}
+	if addrs := resolve(&HostOpts{MaxAddressResolution: 1}); len(addrs) != 2 {
+		t.Errorf("expected 2 addresses, got %v", addrs)
+	}
+	// only the nested address is used
+	if addrs := resolve(&HostOpts{MaxResolveFanout: 1}); len(addrs) != 1 {
+		t.Errorf("expected 1 address, got %v", addrs)
+	}
+
+	// exactly the given number of resolutions are performed
+	for _, limit := range []int{1, 2} {
+		counting := &countingBackend{MockBackend: *backend}
+		h, err := NewHost(ctx, swarmt.GenSwarm(t, ctx), &HostOpts{
+			MultiaddrResolver:    &madns.Resolver{Backend: counting},
+			MaxAddressResolution: limit,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pi := peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{
+			ma.StringCast("/dnsaddr/example.com"),
+			ma.StringCast("/dnsaddr/bar.example.com"),
+		}}
+		if _, err := h.resolveAddrs(ctx, pi); err != nil {
+			t.Fatal(err)
+		}
+		h.Close()
+		if counting.lookups != limit {
+			t.Errorf("expected %d resolutions, got %d", limit, counting.lookups)
+		}
+	}
+}
+
+// countingBackend counts TXT lookups.
+type countingBackend struct {
+	madns.MockBackend
+	mx      sync.Mutex
+	lookups int
+}
+
+func (b *countingBackend) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	b.mx.Lock()
+	b.lookups++
+	b.mx.Unlock()
+	return b.MockBackend.LookupTXT(ctx, name)
+}
+
+func TestDNSResolverCachePerHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingBackend{MockBackend: madns.MockBackend{
+		TXT: map[string][]string{
+			"_dnsaddr.example.com": []string{"dnsaddr=/ip4/192.0.2.1/tcp/1/p2p/" + p.Pretty()},
+		},
+	}}
+	opts := &HostOpts{DNSResolver: backend}
+	pi := peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/dnsaddr/example.com")}}
+
+	var hosts []*BasicHost
+	for i := 0; i < 2; i++ {
+		h, err := NewHost(ctx, swarmt.GenSwarm(t, ctx), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		hosts = append(hosts, h)
+	}
+	for _, h := range append(hosts, hosts...) {
+		if _, err := h.resolveAddrs(ctx, pi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each host looked the name up once
+	if backend.lookups != 2 {
+		t.Errorf("expected 2 lookups, got %d", backend.lookups)
+	}
+}
+
+// gatedBackend blocks TXT lookups until the gate is closed and counts them.
+type gatedBackend struct {
+	madns.MockBackend
+	gate     chan struct{}
+	mx       sync.Mutex
+	lookups  int
+	canceled chan struct{}
+}
+
+func (b *gatedBackend) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	b.mx.Lock()
+	b.lookups++
+	b.mx.Unlock()
+	select {
+	case <-b.gate:
+		return b.MockBackend.LookupTXT(ctx, name)
+	case <-ctx.Done():
+		close(b.canceled)
+		return nil, ctx.Err()
+	}
+}
+
+func TestConcurrentConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h2 := New(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	backend := &gatedBackend{
+		MockBackend: madns.MockBackend{
+			TXT: map[string][]string{
+				"_dnsaddr.example.com": []string{
+					"dnsaddr=" + h2.Addrs()[0].String() + "/p2p/" + h2.ID().Pretty(),
+				},
+			},
+		},
+		gate:     make(chan struct{}),
+		canceled: make(chan struct{}),
+	}
+	h1 := New(swarmt.GenSwarm(t, ctx), &madns.Resolver{Backend: backend})
+	defer h1.Close()
+
+	pi := peer.AddrInfo{ID: h2.ID(), Addrs: []ma.Multiaddr{ma.StringCast("/dnsaddr/example.com")}}
+
+	// the caller starting the attempt gives up, the others get the result.
+	cctx, ccancel := context.WithCancel(ctx)
+	errs := make(chan error, 5)
+	go func() { errs <- h1.Connect(cctx, pi) }()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		go func() { errs <- h1.Connect(ctx, pi) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	ccancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected the canceled call to return, got %v", err)
+	}
+	close(backend.gate)
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.lookups != 1 {
+		t.Errorf("expected a single resolution, got %d", backend.lookups)
+	}
+	if stats := h1.DialStats(); stats.Wins[AddrClassPrivate] != 1 || stats.Failures != 0 {
+		t.Errorf("expected a single dial, got %v", stats)
+	}
+}
+
+func TestConnectCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := test.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &gatedBackend{gate: make(chan struct{}), canceled: make(chan struct{})}
+	h := New(swarmt.GenSwarm(t, ctx), &madns.Resolver{Backend: backend})
+	defer h.Close()
+
+	pi := peer.AddrInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/dnsaddr/example.com")}}
+	cctx, ccancel := context.WithCancel(ctx)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- h.Connect(cctx, pi) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	ccancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != context.Canceled {
+			t.Fatalf("expected the call to be canceled, got %v", err)
+		}
+	}
+	select {
+	case <-backend.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the attempt to be aborted")
+	}
+}
+
+func TestDiffAddrs(t *testing.T) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	b := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	c := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+
+	added, removed := diffAddrs([]ma.Multiaddr{a, b}, []ma.Multiaddr{c, b})
+	if len(added) != 1 || !added[0].Equal(c) {
+		t.Errorf("expected %s to be added, got %s", c, added)
+	}
+	if len(removed) != 1 || !removed[0].Equal(a) {
+		t.Errorf("expected %s to be removed, got %s", a, removed)
+	}
+
+	if added, removed := diffAddrs([]ma.Multiaddr{a, b}, []ma.Multiaddr{b, a}); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no changes, got %s added and %s removed", added, removed)
+	}
+}
+
+func TestLocalAddressesUpdatedEvent(t *testing.T) {
+	ctx := context.Background()
+	extra := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	var mx sync.Mutex
+	withExtra := true
+	h, err := NewHost(ctx, swarmt.GenSwarm(t, ctx), &HostOpts{
+		AddrsFactory: func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			mx.Lock()
+			defer mx.Unlock()
+			if withExtra {
+				addrs = append(addrs, extra)
+			}
+			return addrs
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	sub, err := h.EventBus().Subscribe(&event.EvtLocalAddressesUpdated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	h.Start()
+
+	nextEvent := func() event.EvtLocalAddressesUpdated {
+		t.Helper()
+		select {
+		case evt := <-sub.Out():
+			return evt.(event.EvtLocalAddressesUpdated)
+		case <-time.After(time.Second):
+			t.Fatal("expected an addresses updated event")
+		}
+		return event.EvtLocalAddressesUpdated{}
+	}
+
+	// a new listener is announced right away.
+	if err := h.Network().Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")); err != nil {
+		t.Fatal(err)
+	}
+	evt := nextEvent()
+	if !evt.Diffs || len(evt.Removed) != 0 || len(evt.Current) != len(h.Addrs()) {
+		t.Fatalf("unexpected event: %+v", evt)
+	}
+	added := 0
+	for _, ua := range evt.Current {
+		if ua.Action == event.Added {
+			added++
+		} else if ua.Action != event.Maintained {
+			t.Errorf("unexpected action for %s: %d", ua.Address, ua.Action)
+		}
+	}
+	if added != 1 {
+		t.Errorf("expected one new address, got %d", added)
+	}
+
+	// and so are the addresses removed by the AddrsFactory once signaled.
+	mx.Lock()
+	withExtra = false
+	mx.Unlock()
+	h.SignalAddressChange()
+	evt = nextEvent()
+	if len(evt.Removed) != 1 || !evt.Removed[0].Address.Equal(extra) || evt.Removed[0].Action != event.Removed {
+		t.Fatalf("expected %s to be removed, got %+v", extra, evt.Removed)
+	}
+
+	select {
+	case evt := <-sub.Out():
+		t.Fatalf("unexpected event: %+v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
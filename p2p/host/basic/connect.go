@@ -0,0 +1,102 @@
+package basichost
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+
+	goprocessctx "github.com/jbenet/goprocess/context"
+)
+
+// connectCall is an attempt to connect to a peer, shared by the concurrent
+// Connect calls for the peer.
+type connectCall struct {
+	done chan struct{}
+	err  error
+
+	// the number of Connect calls waiting for the attempt; it's aborted once
+	// all of them returned. Guarded by connectMx.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// joinConnect returns the attempt to connect to the peer, starting it if
+// there's none.
+func (h *BasicHost) joinConnect(ctx context.Context, p peer.ID) *connectCall {
+	h.connectMx.Lock()
+	defer h.connectMx.Unlock()
+
+	if c, ok := h.connects[p]; ok {
+		c.waiters++
+		return c
+	}
+
+	if h.connects == nil {
+		h.connects = make(map[peer.ID]*connectCall)
+	}
+	c := &connectCall{done: make(chan struct{}), waiters: 1}
+	h.connects[p] = c
+
+	// The attempt outlives the caller that started it, but keeps the values
+	// of its context, e.g., the dial options.
+	cctx, cancel := context.WithCancel(goprocessctx.WithProcessClosing(detachedContext{ctx}, h.proc))
+	c.cancel = cancel
+	go func() {
+		err := h.connect(cctx, p)
+		cancel()
+
+		h.connectMx.Lock()
+		if h.connects[p] == c {
+			delete(h.connects, p)
+		}
+		h.connectMx.Unlock()
+
+		c.err = err
+		close(c.done)
+	}()
+	return c
+}
+
+// leaveConnect is called by Connect calls giving up on the attempt before it
+// completed. The last one aborts it.
+func (h *BasicHost) leaveConnect(p peer.ID, c *connectCall) {
+	h.connectMx.Lock()
+	defer h.connectMx.Unlock()
+
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	// later calls start a new attempt.
+	if h.connects[p] == c {
+		delete(h.connects, p)
+	}
+}
+
+// connect resolves the addresses of the peer and dials it.
+func (h *BasicHost) connect(ctx context.Context, p peer.ID) error {
+	resolved, err := h.resolveAddrs(ctx, h.Peerstore().PeerInfo(p))
+	if err != nil {
+		return err
+	}
+	h.Peerstore().AddAddrs(p, resolved, peerstore.TempAddrTTL)
+
+	return h.dialPeer(ctx, p)
+}
+
+// detachedContext carries the values of its parent, but not its deadline and
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
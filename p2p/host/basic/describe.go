@@ -0,0 +1,45 @@
+package basichost
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Describer is implemented by hosts that can summarize their state and
+// configuration for diagnostics.
+type Describer interface {
+	Describe() *HostDescription
+}
+
+// HostDescription is a JSON-marshalable summary of a host, returned by
+// Describe.
+type HostDescription struct {
+	ID          peer.ID        `json:"id"`
+	Addrs       []ma.Multiaddr `json:"addrs"`
+	ListenAddrs []ma.Multiaddr `json:"listenAddrs"`
+	Protocols   []string       `json:"protocols"`
+	Peers       int            `json:"peers"`
+
+	// Routed is set if the host is wrapped with a routing system.
+	Routed bool `json:"routed,omitempty"`
+
+	// Config is the host's Description, typically the configuration the host
+	// was constructed with.
+	Config interface{} `json:"config,omitempty"`
+}
+
+var _ Describer = (*BasicHost)(nil)
+
+// Describe returns a summary of the host's current state along with its
+// Description.
+func (h *BasicHost) Describe() *HostDescription {
+	return &HostDescription{
+		ID:          h.ID(),
+		Addrs:       h.Addrs(),
+		ListenAddrs: h.Network().ListenAddresses(),
+		Protocols:   h.Mux().Protocols(),
+		Peers:       len(h.Network().Peers()),
+		Config:      h.Description,
+	}
+}
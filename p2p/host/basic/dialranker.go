@@ -0,0 +1,269 @@
+package basichost
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+var (
+	// PublicDialDelay is how long DefaultDialRanker waits before dialing
+	// public addresses if the peer has private addresses.
+	PublicDialDelay = 100 * time.Millisecond
+
+	// IPv4DialDelay is how long DefaultDialRanker waits after dialing public
+	// IPv6 addresses before dialing public IPv4 (and other) addresses.
+	IPv4DialDelay = 250 * time.Millisecond
+
+	// RelayDialDelay is how long DefaultDialRanker waits after dialing the
+	// direct addresses before dialing relay addresses.
+	RelayDialDelay = 500 * time.Millisecond
+)
+
+// AddrClass classifies addresses for dial ranking and statistics.
+type AddrClass int
+
+const (
+	// AddrClassOther is the class of addresses not in any other class, e.g.,
+	// DNS addresses.
+	AddrClassOther AddrClass = iota
+	// AddrClassPrivate is the class of loopback, LAN and link local IP
+	// addresses.
+	AddrClassPrivate
+	// AddrClassPublicIPv6 is the class of public IPv6 addresses.
+	AddrClassPublicIPv6
+	// AddrClassPublicIPv4 is the class of public IPv4 addresses.
+	AddrClassPublicIPv4
+	// AddrClassRelay is the class of /p2p-circuit addresses.
+	AddrClassRelay
+)
+
+func (c AddrClass) String() string {
+	switch c {
+	case AddrClassPrivate:
+		return "private"
+	case AddrClassPublicIPv6:
+		return "public-ip6"
+	case AddrClassPublicIPv4:
+		return "public-ip4"
+	case AddrClassRelay:
+		return "relay"
+	default:
+		return "other"
+	}
+}
+
+// ClassifyAddr returns the class of the given address.
+func ClassifyAddr(a ma.Multiaddr) AddrClass {
+	if _, err := a.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return AddrClassRelay
+	}
+	if manet.IsPrivateAddr(a) {
+		return AddrClassPrivate
+	}
+	switch a.Protocols()[0].Code {
+	case ma.P_IP6:
+		return AddrClassPublicIPv6
+	case ma.P_IP4:
+		return AddrClassPublicIPv4
+	}
+	return AddrClassOther
+}
+
+// AddrDelay is an address to dial and how long to wait before dialing it,
+// relative to the start of the dial.
+type AddrDelay struct {
+	Addr  ma.Multiaddr
+	Delay time.Duration
+}
+
+// DialRanker orders and staggers the dials to a peer's addresses. Addresses
+// it omits aren't dialed.
+type DialRanker func(addrs []ma.Multiaddr) []AddrDelay
+
+// DefaultDialRanker dials private addresses first, then public IPv6 addresses
+// (after PublicDialDelay), then public IPv4 and other addresses (after another
+// IPv4DialDelay) and finally relay addresses (after another RelayDialDelay).
+// Delays only apply if there are addresses in the preceding classes.
+func DefaultDialRanker(addrs []ma.Multiaddr) []AddrDelay {
+	byClass := make(map[AddrClass][]ma.Multiaddr)
+	for _, a := range addrs {
+		c := ClassifyAddr(a)
+		byClass[c] = append(byClass[c], a)
+	}
+
+	out := make([]AddrDelay, 0, len(addrs))
+	var delay time.Duration
+	add := func(as []ma.Multiaddr, wait time.Duration) {
+		if len(as) == 0 {
+			return
+		}
+		if len(out) > 0 {
+			delay += wait
+		}
+		for _, a := range as {
+			out = append(out, AddrDelay{Addr: a, Delay: delay})
+		}
+	}
+	add(byClass[AddrClassPrivate], 0)
+	add(byClass[AddrClassPublicIPv6], PublicDialDelay)
+	if len(byClass[AddrClassPublicIPv6]) == 0 {
+		add(append(byClass[AddrClassPublicIPv4], byClass[AddrClassOther]...), PublicDialDelay)
+	} else {
+		add(append(byClass[AddrClassPublicIPv4], byClass[AddrClassOther]...), IPv4DialDelay)
+	}
+	add(byClass[AddrClassRelay], RelayDialDelay)
+	return out
+}
+
+// NoDelayDialRanker dials all addresses at once.
+func NoDelayDialRanker(addrs []ma.Multiaddr) []AddrDelay {
+	out := make([]AddrDelay, len(addrs))
+	for i, a := range addrs {
+		out[i] = AddrDelay{Addr: a}
+	}
+	return out
+}
+
+// AddrDialer is implemented by networks able to dial a specific address of a
+// peer. The host only ranks and staggers dials on such networks; otherwise,
+// it leaves dialing to the network's DialPeer.
+type AddrDialer interface {
+	DialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr) (network.Conn, error)
+}
+
+// DialStats are the counters of the host's outbound dials (see Connect).
+type DialStats struct {
+	// Wins counts the successful dials by the class of the address the
+	// connection was established on.
+	Wins map[AddrClass]uint64
+	// Failures counts the failed dials.
+	Failures uint64
+}
+
+// DialStats returns the counters of the host's outbound dials.
+func (h *BasicHost) DialStats() DialStats {
+	h.dialStatsMx.Lock()
+	defer h.dialStatsMx.Unlock()
+
+	stats := DialStats{
+		Wins:     make(map[AddrClass]uint64, len(h.dialStats.Wins)),
+		Failures: h.dialStats.Failures,
+	}
+	for c, n := range h.dialStats.Wins {
+		stats.Wins[c] = n
+	}
+	return stats
+}
+
+func (h *BasicHost) recordDial(c network.Conn, err error) {
+	h.dialStatsMx.Lock()
+	defer h.dialStatsMx.Unlock()
+
+	if err != nil {
+		h.dialStats.Failures++
+		return
+	}
+	if h.dialStats.Wins == nil {
+		h.dialStats.Wins = make(map[AddrClass]uint64)
+	}
+	h.dialStats.Wins[ClassifyAddr(c.RemoteMultiaddr())]++
+}
+
+// dial connects to the peer, ranking its addresses if the network supports
+// dialing specific addresses (e.g. mocknet). Other networks, such as the swarm,
+// dial the peer's addresses themselves.
+func (h *BasicHost) dial(ctx context.Context, p peer.ID) (network.Conn, error) {
+	ad, ok := h.Network().(AddrDialer)
+	if !ok {
+		return h.Network().DialPeer(ctx, p)
+	}
+	ranked := h.dialRanker(h.Peerstore().Addrs(p))
+	if len(ranked) == 0 {
+		return h.Network().DialPeer(ctx, p)
+	}
+	return dialRanked(ctx, ranked, func(ctx context.Context, a ma.Multiaddr) (network.Conn, error) {
+		return ad.DialAddr(ctx, p, a)
+	})
+}
+
+// dialRanked dials the ranked addresses, returning the first connection
+// established. Once all pending dials failed, the next address is dialed
+// without waiting for its delay. The pending dials are canceled once one of
+// them succeeded, and the connections they may still establish are closed.
+func dialRanked(ctx context.Context, ranked []AddrDelay, dial func(context.Context, ma.Multiaddr) (network.Conn, error)) (network.Conn, error) {
+	ranked = append([]AddrDelay(nil), ranked...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Delay < ranked[j].Delay })
+
+	// cancels the remaining dials once one succeeded.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		addr ma.Multiaddr
+		conn network.Conn
+		err  error
+	}
+	results := make(chan result, len(ranked))
+
+	// closes the connections established by the pending dials.
+	drain := func(pending int) {
+		go func() {
+			for ; pending > 0; pending-- {
+				if res := <-results; res.conn != nil {
+					res.conn.Close()
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	next, pending := 0, 0
+	var errs []string
+	for {
+		// start the due dials, or the next one if none is pending.
+		for next < len(ranked) && (pending == 0 || ranked[next].Delay <= time.Since(start)) {
+			a := ranked[next].Addr
+			go func() {
+				c, err := dial(ctx, a)
+				results <- result{a, c, err}
+			}()
+			next++
+			pending++
+		}
+
+		var due <-chan time.Time
+		stopTimer := func() {}
+		if next < len(ranked) {
+			timer := time.NewTimer(ranked[next].Delay - time.Since(start))
+			due, stopTimer = timer.C, func() { timer.Stop() }
+		}
+
+		select {
+		case res := <-results:
+			stopTimer()
+			pending--
+			if res.err == nil {
+				cancel()
+				drain(pending)
+				return res.conn, nil
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", res.addr, res.err))
+			if pending == 0 && next == len(ranked) {
+				return nil, fmt.Errorf("all dials failed: %v", errs)
+			}
+		case <-due:
+		case <-ctx.Done():
+			stopTimer()
+			drain(pending)
+			return nil, ctx.Err()
+		}
+	}
+}
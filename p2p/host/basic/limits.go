@@ -0,0 +1,199 @@
+package basichost
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// LimitPolicy determines what happens to inbound streams exceeding their
+// protocol's StreamLimits.
+type LimitPolicy int
+
+const (
+	// LimitReset resets streams exceeding the limits.
+	LimitReset LimitPolicy = iota
+	// LimitWait makes streams exceeding the limits wait for an active stream
+	// to finish (see StreamLimits.WaitTimeout).
+	LimitWait
+)
+
+// StreamLimits limits the number of inbound streams concurrently handled by
+// a stream handler. A limit of 0 means no limit.
+type StreamLimits struct {
+	// MaxStreams is the maximum number of streams handled at once.
+	MaxStreams int
+	// MaxStreamsPerPeer is the maximum number of streams from a single peer
+	// handled at once.
+	MaxStreamsPerPeer int
+
+	// Policy determines what happens to streams exceeding the limits.
+	Policy LimitPolicy
+	// WaitTimeout is the maximum time a stream waits with the LimitWait
+	// policy before it's reset. If 0, streams wait until the host closes.
+	WaitTimeout time.Duration
+}
+
+// StreamLimitStats are the counters of a limited stream handler.
+type StreamLimitStats struct {
+	// Active is the number of streams currently being handled.
+	Active int
+	// Waiting is the number of streams currently waiting to be handled.
+	Waiting int
+
+	// Accepted is the number of streams that were handled.
+	Accepted uint64
+	// Queued is the number of streams that had to wait before being
+	// handled (or reset).
+	Queued uint64
+	// Rejected is the number of streams reset because of the limits,
+	// including streams that timed out waiting.
+	Rejected uint64
+}
+
+type streamLimiter struct {
+	limits StreamLimits
+
+	mx      sync.Mutex
+	perPeer map[peer.ID]int
+	// closed and replaced whenever a stream finishes to wake up waiters.
+	released chan struct{}
+	stats    StreamLimitStats
+}
+
+func newStreamLimiter(limits StreamLimits) *streamLimiter {
+	return &streamLimiter{
+		limits:   limits,
+		perPeer:  make(map[peer.ID]int),
+		released: make(chan struct{}),
+	}
+}
+
+// canAcceptLocked must be called with the lock held.
+func (l *streamLimiter) canAcceptLocked(p peer.ID) bool {
+	if l.limits.MaxStreams > 0 && l.stats.Active >= l.limits.MaxStreams {
+		return false
+	}
+	if l.limits.MaxStreamsPerPeer > 0 && l.perPeer[p] >= l.limits.MaxStreamsPerPeer {
+		return false
+	}
+	return true
+}
+
+// acquire returns true once the stream from p may be handled, and false if it
+// should be reset. closing aborts waiting.
+func (l *streamLimiter) acquire(p peer.ID, closing <-chan struct{}) bool {
+	var timeout <-chan time.Time
+	queued := false
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for {
+		if l.canAcceptLocked(p) {
+			if queued {
+				l.stats.Waiting--
+			}
+			l.stats.Active++
+			l.stats.Accepted++
+			l.perPeer[p]++
+			return true
+		}
+
+		if l.limits.Policy != LimitWait {
+			l.stats.Rejected++
+			return false
+		}
+
+		if !queued {
+			queued = true
+			l.stats.Queued++
+			l.stats.Waiting++
+			if l.limits.WaitTimeout > 0 {
+				t := time.NewTimer(l.limits.WaitTimeout)
+				defer t.Stop()
+				timeout = t.C
+			}
+		}
+
+		released := l.released
+		l.mx.Unlock()
+		var ok bool
+		select {
+		case <-released:
+			ok = true
+		case <-timeout:
+		case <-closing:
+		}
+		l.mx.Lock()
+
+		if !ok {
+			l.stats.Waiting--
+			l.stats.Rejected++
+			return false
+		}
+	}
+}
+
+func (l *streamLimiter) release(p peer.ID) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.stats.Active--
+	if l.perPeer[p]--; l.perPeer[p] <= 0 {
+		delete(l.perPeer, p)
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *streamLimiter) getStats() StreamLimitStats {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.stats
+}
+
+// SetStreamHandlerWithLimits sets the protocol handler on the Host's Mux like
+// SetStreamHandler, limiting the number of streams handled concurrently. See
+// StreamLimitStats for the counters.
+func (h *BasicHost) SetStreamHandlerWithLimits(pid protocol.ID, limits StreamLimits, handler network.StreamHandler) {
+	l := newStreamLimiter(limits)
+	h.SetStreamHandler(pid, func(s network.Stream) {
+		p := s.Conn().RemotePeer()
+		if !l.acquire(p, h.proc.Closing()) {
+			log.Debugf("stream limit for %s reached; resetting stream from %s", pid, p)
+			s.Reset()
+			return
+		}
+		defer l.release(p)
+		handler(s)
+	})
+
+	h.limitsMx.Lock()
+	if h.limiters == nil {
+		h.limiters = make(map[protocol.ID]*streamLimiter)
+	}
+	h.limiters[pid] = l
+	h.limitsMx.Unlock()
+}
+
+// StreamLimitStats returns the counters of the stream handler set for the
+// given protocol with SetStreamHandlerWithLimits. It returns false if the
+// protocol's handler isn't limited.
+func (h *BasicHost) StreamLimitStats(pid protocol.ID) (StreamLimitStats, bool) {
+	h.limitsMx.Lock()
+	l, ok := h.limiters[pid]
+	h.limitsMx.Unlock()
+	if !ok {
+		return StreamLimitStats{}, false
+	}
+	return l.getStats(), true
+}
+
+func (h *BasicHost) removeStreamLimiter(pid protocol.ID) {
+	h.limitsMx.Lock()
+	delete(h.limiters, pid)
+	h.limitsMx.Unlock()
+}
@@ -0,0 +1,82 @@
+package basichost
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EvtListenerClosed is emitted on the host's event bus when one of the
+// network's listeners closes while the host is still running.
+type EvtListenerClosed struct {
+	// Addr is the address the listener was bound to.
+	Addr ma.Multiaddr
+}
+
+// listenNotifiee tracks the addresses the network listens on and emits an
+// EvtListenerClosed when the network tells us a listener closed.
+func (h *BasicHost) listenNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ListenF: func(_ network.Network, a ma.Multiaddr) {
+			h.listenMx.Lock()
+			h.listenAddrs[string(a.Bytes())] = a
+			h.listenMx.Unlock()
+			h.SignalAddressChange()
+		},
+		ListenCloseF: func(_ network.Network, a ma.Multiaddr) {
+			h.listenerClosed(a)
+		},
+	}
+}
+
+// checkListeners compares the set of tracked listen addresses with the
+// addresses the network is actually listening on, and emits an
+// EvtListenerClosed for every listener that has disappeared.
+//
+// It's a fallback for networks that don't send ListenClose notifications (the
+// swarm only notifies on Listen), called on the periodic address check.
+func (h *BasicHost) checkListeners() {
+	current := make(map[string]struct{})
+	for _, a := range h.Network().ListenAddresses() {
+		current[string(a.Bytes())] = struct{}{}
+	}
+
+	h.listenMx.Lock()
+	var closed []ma.Multiaddr
+	for k, a := range h.listenAddrs {
+		if _, ok := current[k]; !ok {
+			closed = append(closed, a)
+		}
+	}
+	h.listenMx.Unlock()
+
+	for _, a := range closed {
+		h.listenerClosed(a)
+	}
+}
+
+func (h *BasicHost) listenerClosed(a ma.Multiaddr) {
+	// Listeners are expected to close when we shut down.
+	select {
+	case <-h.proc.Closing():
+		return
+	default:
+	}
+	if h.isDraining() {
+		return
+	}
+
+	h.listenMx.Lock()
+	k := string(a.Bytes())
+	_, tracked := h.listenAddrs[k]
+	delete(h.listenAddrs, k)
+	h.listenMx.Unlock()
+
+	if !tracked {
+		return
+	}
+
+	log.Warningf("listener on %s closed unexpectedly", a)
+	h.emitters.evtListenerClosed.Emit(EvtListenerClosed{Addr: a})
+	h.SignalAddressChange()
+}
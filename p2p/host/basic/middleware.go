@@ -0,0 +1,66 @@
+package basichost
+
+import (
+	"runtime/debug"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// StreamMiddleware wraps a stream handler, e.g. to log, authorize or measure
+// inbound streams.
+type StreamMiddleware func(next network.StreamHandler) network.StreamHandler
+
+// Use registers middleware wrapping the handlers of all protocols. Middleware
+// registered first wraps the middleware registered later, and global
+// middleware wraps the per-protocol middleware (see UseFor).
+//
+// Middleware applies to handlers set before and after calling Use.
+func (h *BasicHost) Use(mw ...StreamMiddleware) {
+	h.middlewareMx.Lock()
+	defer h.middlewareMx.Unlock()
+	h.middleware = append(h.middleware, mw...)
+}
+
+// UseFor registers middleware wrapping the handler of the given protocol. The
+// protocol is matched against the protocol the handler was set with, not the
+// negotiated one (see SetStreamHandlerMatch).
+func (h *BasicHost) UseFor(pid protocol.ID, mw ...StreamMiddleware) {
+	h.middlewareMx.Lock()
+	defer h.middlewareMx.Unlock()
+	if h.protoMiddleware == nil {
+		h.protoMiddleware = make(map[protocol.ID][]StreamMiddleware)
+	}
+	h.protoMiddleware[pid] = append(h.protoMiddleware[pid], mw...)
+}
+
+// wrapHandler wraps the handler set for the given protocol with the currently
+// registered middleware.
+func (h *BasicHost) wrapHandler(pid protocol.ID, handler network.StreamHandler) network.StreamHandler {
+	h.middlewareMx.Lock()
+	global, proto := h.middleware, h.protoMiddleware[pid]
+	h.middlewareMx.Unlock()
+
+	for i := len(proto) - 1; i >= 0; i-- {
+		handler = proto[i](handler)
+	}
+	for i := len(global) - 1; i >= 0; i-- {
+		handler = global[i](handler)
+	}
+	return handler
+}
+
+// PanicRecovery is a StreamMiddleware recovering from panics in stream
+// handlers. It logs the panic along with the protocol and the remote peer, and
+// resets the stream.
+func PanicRecovery(next network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("panic in %s stream handler (peer %s): %s\n%s", s.Protocol(), s.Conn().RemotePeer(), r, debug.Stack())
+				s.Reset()
+			}
+		}()
+		next(s)
+	}
+}
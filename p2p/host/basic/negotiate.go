@@ -0,0 +1,161 @@
+package basichost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	msmux "github.com/multiformats/go-multistream"
+)
+
+// NegotiationFailure is the reason protocol negotiation failed.
+type NegotiationFailure int
+
+const (
+	// NegotiationFailed means negotiation failed for another reason, e.g.,
+	// because the peer sent a malformed response.
+	NegotiationFailed NegotiationFailure = iota
+	// NegotiationNotSupported means the peer supports none of the protocols.
+	NegotiationNotSupported
+	// NegotiationTimedOut means negotiation didn't complete in time.
+	NegotiationTimedOut
+	// NegotiationCanceled means the context was canceled.
+	NegotiationCanceled
+	// NegotiationReset means the stream was reset or closed by the peer.
+	NegotiationReset
+)
+
+func (f NegotiationFailure) String() string {
+	switch f {
+	case NegotiationNotSupported:
+		return "protocol not supported"
+	case NegotiationTimedOut:
+		return "timed out"
+	case NegotiationCanceled:
+		return "canceled"
+	case NegotiationReset:
+		return "stream reset"
+	default:
+		return "failed"
+	}
+}
+
+// NegotiationError is returned by NewStream when it fails to negotiate the
+// protocol of a new stream.
+type NegotiationError struct {
+	Peer      peer.ID
+	Protocols []protocol.ID
+	Failure   NegotiationFailure
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *NegotiationError) Error() string {
+	return fmt.Sprintf("negotiating %v with %s: %s: %s", e.Protocols, e.Peer, e.Failure, e.Err)
+}
+
+// Unwrap returns the underlying error, so errors.Is and errors.As match the
+// errors negotiation used to return directly (e.g. multistream.ErrNotSupported).
+func (e *NegotiationError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout returns true if negotiation timed out.
+func (e *NegotiationError) Timeout() bool {
+	return e.Failure == NegotiationTimedOut
+}
+
+type eagerNegotiationCtxKey struct{}
+
+// WithEagerNegotiation returns a context instructing NewStream to negotiate
+// the stream's protocol before returning, instead of lazily on the first read
+// or write. Negotiation is aborted if it doesn't complete within the given
+// timeout (if positive) or before the context is done.
+//
+// Negotiation failures are then returned by NewStream as *NegotiationError,
+// allowing callers to fall back to other protocols or peers.
+func WithEagerNegotiation(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, eagerNegotiationCtxKey{}, timeout)
+}
+
+// getEagerNegotiation returns true and the negotiation timeout if the context
+// was created by WithEagerNegotiation.
+func getEagerNegotiation(ctx context.Context) (bool, time.Duration) {
+	timeout, ok := ctx.Value(eagerNegotiationCtxKey{}).(time.Duration)
+	return ok, timeout
+}
+
+// negotiate selects one of the given protocols on the stream, resetting the
+// stream if negotiation doesn't complete within the timeout (if positive) or
+// before the context is done.
+func negotiate(ctx context.Context, s network.Stream, timeout time.Duration, pids []protocol.ID) (protocol.ID, error) {
+	p := s.Conn().RemotePeer()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	type result struct {
+		proto string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		proto, err := msmux.SelectOneOf(pidsToStrings(pids), s)
+		done <- result{proto, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			s.Reset()
+			return "", &NegotiationError{Peer: p, Protocols: pids, Failure: negotiationFailure(res.err), Err: res.err}
+		}
+		return protocol.ID(res.proto), nil
+	case <-timer:
+		s.Reset()
+		<-done
+		return "", &NegotiationError{
+			Peer:      p,
+			Protocols: pids,
+			Failure:   NegotiationTimedOut,
+			Err:       fmt.Errorf("no response after %s", timeout),
+		}
+	case <-ctx.Done():
+		s.Reset()
+		<-done
+		failure := NegotiationCanceled
+		if ctx.Err() == context.DeadlineExceeded {
+			failure = NegotiationTimedOut
+		}
+		return "", &NegotiationError{Peer: p, Protocols: pids, Failure: failure, Err: ctx.Err()}
+	}
+}
+
+// negotiationFailure classifies an error returned by multistream.
+func negotiationFailure(err error) NegotiationFailure {
+	switch err {
+	case msmux.ErrNotSupported:
+		return NegotiationNotSupported
+	case mux.ErrReset, io.EOF, io.ErrUnexpectedEOF:
+		return NegotiationReset
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return NegotiationTimedOut
+	}
+	// Stream muxers return their own reset errors, with the same message.
+	if err.Error() == mux.ErrReset.Error() {
+		return NegotiationReset
+	}
+	return NegotiationFailed
+}
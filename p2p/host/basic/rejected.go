@@ -0,0 +1,132 @@
+package basichost
+
+import (
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	"github.com/jbenet/goprocess"
+)
+
+// ErrProtocolNotSupported is returned by NewStream, without opening a stream,
+// if the peer recently rejected all the requested protocols.
+var ErrProtocolNotSupported = errors.New("protocol not supported")
+
+// DefaultRejectedProtocolsTTL is the default value for
+// HostOpts.RejectedProtocolsTTL.
+var DefaultRejectedProtocolsTTL = 10 * time.Minute
+
+// rejectedProtocolsKey is the peerstore metadata key of the protocols a peer
+// rejected, mapped to the time the rejection expires.
+const rejectedProtocolsKey = "basichost/rejected-protocols"
+
+func init() {
+	// for datastore-backed peerstores
+	gob.Register(map[string]time.Time{})
+}
+
+// rejectedProtocols returns a copy of the unexpired protocol rejections of
+// the peer.
+func (h *BasicHost) rejectedProtocols(p peer.ID) map[string]time.Time {
+	var rejected map[string]time.Time
+	if v, err := h.Peerstore().Get(p, rejectedProtocolsKey); err == nil {
+		rejected, _ = v.(map[string]time.Time)
+	}
+
+	now := time.Now()
+	out := make(map[string]time.Time, len(rejected))
+	for proto, expires := range rejected {
+		if now.Before(expires) {
+			out[proto] = expires
+		}
+	}
+	return out
+}
+
+// rejectProtocols records that the peer doesn't support the given protocols.
+func (h *BasicHost) rejectProtocols(p peer.ID, pids []protocol.ID) {
+	if h.rejectTTL < 0 {
+		return
+	}
+
+	h.rejectMx.Lock()
+	defer h.rejectMx.Unlock()
+
+	rejected := h.rejectedProtocols(p)
+	expires := time.Now().Add(h.rejectTTL)
+	for _, pid := range pids {
+		rejected[string(pid)] = expires
+	}
+	if err := h.Peerstore().Put(p, rejectedProtocolsKey, rejected); err != nil {
+		log.Debugf("recording protocols rejected by %s: %s", p, err)
+	}
+}
+
+// clearRejectedProtocols forgets the protocols rejected by the peer.
+func (h *BasicHost) clearRejectedProtocols(p peer.ID) {
+	h.rejectMx.Lock()
+	defer h.rejectMx.Unlock()
+
+	if len(h.rejectedProtocols(p)) == 0 {
+		return
+	}
+	if err := h.Peerstore().Put(p, rejectedProtocolsKey, map[string]time.Time{}); err != nil {
+		log.Debugf("clearing protocols rejected by %s: %s", p, err)
+	}
+}
+
+// unrejectProtocols forgets the rejections of the given protocols by the peer,
+// e.g. because it advertised them.
+func (h *BasicHost) unrejectProtocols(p peer.ID, pids []protocol.ID) {
+	h.rejectMx.Lock()
+	defer h.rejectMx.Unlock()
+
+	rejected := h.rejectedProtocols(p)
+	n := len(rejected)
+	for _, pid := range pids {
+		delete(rejected, string(pid))
+	}
+	if len(rejected) == n {
+		return
+	}
+	if err := h.Peerstore().Put(p, rejectedProtocolsKey, rejected); err != nil {
+		log.Debugf("clearing protocols rejected by %s: %s", p, err)
+	}
+}
+
+// watchAdvertisedProtocols forgets the rejections of the protocols peers
+// advertise, until the process closes.
+func (h *BasicHost) watchAdvertisedProtocols(proc goprocess.Process, sub event.Subscription) {
+	defer sub.Close()
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt := e.(event.EvtPeerProtocolsUpdated)
+			h.unrejectProtocols(evt.Peer, evt.Added)
+		case <-proc.Closing():
+			return
+		}
+	}
+}
+
+// withoutRejected returns the protocols the peer didn't recently reject.
+func (h *BasicHost) withoutRejected(p peer.ID, pids []protocol.ID) []protocol.ID {
+	rejected := h.rejectedProtocols(p)
+	if len(rejected) == 0 {
+		return pids
+	}
+	out := make([]protocol.ID, 0, len(pids))
+	for _, pid := range pids {
+		if _, ok := rejected[string(pid)]; !ok {
+			out = append(out, pid)
+		}
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+package basichost
+
+import (
+	"context"
+	"net"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p/p2p/net/resolver"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+var (
+	// DefaultMaxAddressResolution is the default value for
+	// HostOpts.MaxAddressResolution.
+	DefaultMaxAddressResolution = 32
+
+	// DefaultMaxResolveDepth is the default value for HostOpts.MaxResolveDepth.
+	DefaultMaxResolveDepth = 8
+
+	// DefaultMaxResolveFanout is the default value for
+	// HostOpts.MaxResolveFanout.
+	DefaultMaxResolveFanout = 32
+)
+
+// newMultiaddrResolver returns a resolver caching the lookups of the given
+// backend, or of the system resolver if nil. The system resolver doesn't report
+// TTLs, so its answers are only cached for resolver.DefaultMinTTL.
+func newMultiaddrResolver(backend resolver.Backend) *madns.Resolver {
+	if backend == nil {
+		backend = net.DefaultResolver
+	}
+	if _, ok := backend.(*resolver.Cache); !ok {
+		backend = resolver.NewCache(backend)
+	}
+	return &madns.Resolver{Backend: backend}
+}
+
+// EvtPeerIDMismatch is emitted on the host's event bus when a /dnsaddr
+// address of a peer only resolves to addresses of other peers, e.g., because
+// the peer behind the domain changed its identity.
+type EvtPeerIDMismatch struct {
+	// Peer is the peer the address was resolved for.
+	Peer peer.ID
+	// Addr is the resolved address.
+	Addr ma.Multiaddr
+	// Found are the peers the address resolved to.
+	Found []peer.ID
+}
+
+// resolveLimits bound the resolution of a peer's addresses. Negative limits
+// are disabled.
+type resolveLimits struct {
+	// steps is the total number of resolutions.
+	steps int
+	// depth is the nesting of resolutions.
+	depth int
+	// fanout is the number of addresses used from a single resolution.
+	fanout int
+}
+
+func (h *BasicHost) resolveAddrs(ctx context.Context, pi peer.AddrInfo) ([]ma.Multiaddr, error) {
+	type pending struct {
+		addr  ma.Multiaddr
+		depth int
+	}
+
+	limits := h.resolveLimits
+	resolveSteps := 0
+
+	// Recursively resolve all addrs.
+	//
+	// While the toResolve list is non-empty:
+	// * Pop an address off.
+	// * If the address is fully resolved, add it to the resolved list.
+	// * Otherwise, resolve it and add the results to the "to resolve" list.
+	toResolve := make([]pending, 0, len(pi.Addrs))
+	for _, addr := range pi.Addrs {
+		toResolve = append(toResolve, pending{addr: addr})
+	}
+	resolved := make([]ma.Multiaddr, 0, len(pi.Addrs))
+	for len(toResolve) > 0 {
+		// pop the last addr off.
+		next := toResolve[len(toResolve)-1]
+		toResolve = toResolve[:len(toResolve)-1]
+
+		// if it's resolved, add it to the resolved list.
+		if !madns.Matches(next.addr) {
+			resolved = append(resolved, next.addr)
+			continue
+		}
+
+		if limits.depth >= 0 && next.depth >= limits.depth {
+			log.Warningf("peer %s has addresses nested too deeply: %s", pi.ID, next.addr)
+			continue
+		}
+
+		// We've resolved too many addresses. We can keep all the fully
+		// resolved addresses but we'll need to skip the rest.
+		if limits.steps >= 0 && resolveSteps >= limits.steps {
+			log.Warningf(
+				"peer %s asked us to resolve too many addresses: %d/%d",
+				pi.ID,
+				resolveSteps+1,
+				limits.steps,
+			)
+			continue
+		}
+		resolveSteps++
+
+		// otherwise, resolve it. Records of other peers are kept so we can
+		// tell when the address only resolves to other peers.
+		resaddrs, err := h.maResolver.Resolve(ctx, next.addr)
+		if err != nil {
+			log.Infof("error resolving %s: %s", next.addr, err)
+			continue
+		}
+
+		// add the results to the toResolve list.
+		var others []peer.ID
+		used := 0
+		for _, res := range resaddrs {
+			addr, id := peer.SplitAddr(res)
+			if id != "" && id != pi.ID {
+				others = appendPeer(others, id)
+				continue
+			}
+			if addr == nil {
+				continue
+			}
+			if limits.fanout >= 0 && used >= limits.fanout {
+				log.Warningf("%s resolved to too many addresses, using %d", next.addr, used)
+				break
+			}
+			used++
+			toResolve = append(toResolve, pending{addr: addr, depth: next.depth + 1})
+		}
+		if used == 0 && len(others) > 0 {
+			log.Infof("%s resolved to other peers than %s: %s", next.addr, pi.ID, others)
+			h.emitters.evtPeerIDMismatch.Emit(EvtPeerIDMismatch{Peer: pi.ID, Addr: next.addr, Found: others})
+		}
+	}
+
+	return resolved, nil
+}
+
+func appendPeer(ps []peer.ID, p peer.ID) []peer.ID {
+	for _, q := range ps {
+		if q == p {
+			return ps
+		}
+	}
+	return append(ps, p)
+}
@@ -0,0 +1,122 @@
+package basichost
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// splitVersion splits a protocol ID like /myproto/1.2.0 into its base
+// (/myproto/) and its semantic version.
+func splitVersion(pid string) (string, *semver.Version, error) {
+	i := strings.LastIndexByte(pid, '/')
+	if i <= 0 || i == len(pid)-1 {
+		return "", nil, fmt.Errorf("protocol %s has no version", pid)
+	}
+	v, err := semver.NewVersion(pid[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("protocol %s has an invalid version: %s", pid, err)
+	}
+	return pid[:i+1], v, nil
+}
+
+// semverCompatible returns true if a handler for version have can serve
+// streams opened for version want. Versions with major version 0 are
+// unstable, so they're only compatible with the same minor version.
+func semverCompatible(have, want *semver.Version) bool {
+	if have.Major != want.Major {
+		return false
+	}
+	if have.Major == 0 {
+		return have.Minor == want.Minor
+	}
+	return have.Minor >= want.Minor
+}
+
+// SemverMatcher returns a protocol matcher for SetStreamHandlerMatch that
+// matches the protocols a handler for the given versioned protocol can serve.
+// For example, the matcher for /myproto/1.2.0 matches /myproto/1.0.0 through
+// /myproto/1.2.x but neither /myproto/1.3.0 nor /myproto/2.0.0.
+func SemverMatcher(base protocol.ID) (func(string) bool, error) {
+	prefix, have, err := splitVersion(string(base))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(check string) bool {
+		if !strings.HasPrefix(check, prefix) {
+			return false
+		}
+		chprefix, want, err := splitVersion(check)
+		if err != nil || chprefix != prefix {
+			return false
+		}
+		return semverCompatible(have, want)
+	}, nil
+}
+
+// SetStreamHandlerSemver sets the handler for a versioned protocol like
+// /myproto/1.2.0, matching all compatible versions of the protocol (see
+// SemverMatcher). It fails if the protocol has no semantic version.
+func (h *BasicHost) SetStreamHandlerSemver(pid protocol.ID, handler network.StreamHandler) error {
+	m, err := SemverMatcher(pid)
+	if err != nil {
+		return err
+	}
+	h.SetStreamHandlerMatch(pid, m, handler)
+	return nil
+}
+
+type highestVersionCtxKey struct{}
+
+// WithHighestVersion returns a context instructing NewStream to pick the
+// highest version among the given protocols the peer is known to support,
+// instead of the first one in the order given. If the peer isn't known to
+// support any of them, the protocols are negotiated from the highest version
+// down.
+func WithHighestVersion(ctx context.Context) context.Context {
+	return context.WithValue(ctx, highestVersionCtxKey{}, true)
+}
+
+// getHighestVersion returns true if the context was created by
+// WithHighestVersion.
+func getHighestVersion(ctx context.Context) bool {
+	v, _ := ctx.Value(highestVersionCtxKey{}).(bool)
+	return v
+}
+
+// sortByVersion returns the protocols sorted from the highest version down.
+// Protocols without a semantic version keep their order after the versioned
+// ones.
+func sortByVersion(pids []protocol.ID) []protocol.ID {
+	type versioned struct {
+		pid protocol.ID
+		v   *semver.Version
+	}
+	vs := make([]versioned, len(pids))
+	for i, pid := range pids {
+		vs[i].pid = pid
+		_, vs[i].v, _ = splitVersion(string(pid))
+	}
+	sort.SliceStable(vs, func(i, j int) bool {
+		switch {
+		case vs[i].v == nil:
+			return false
+		case vs[j].v == nil:
+			return true
+		}
+		return vs[j].v.LessThan(*vs[i].v)
+	})
+
+	out := make([]protocol.ID, len(vs))
+	for i := range vs {
+		out[i] = vs[i].pid
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package basichost
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Shutdown gracefully shuts the host down:
+//
+//  1. It stops accepting new inbound streams and connections.
+//  2. It tells connected peers (via identify delta) that it no longer supports
+//     any protocols.
+//  3. It waits for the active inbound stream handlers to return.
+//  4. It closes the host like Close does, closing the NAT manager, the
+//     connection manager and the network, in that order.
+//
+// If the context expires before the peers have been notified or before all
+// handlers have returned, the host is closed anyways (resetting the remaining
+// streams) and the context's error is returned.
+func (h *BasicHost) Shutdown(ctx context.Context) error {
+	h.drainMx.Lock()
+	h.draining = true
+	h.drainMx.Unlock()
+
+	var err error
+	select {
+	case <-h.ids.PushProtocolsRemoved(protocol.ConvertFromStrings(h.Mux().Protocols())):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			h.handlers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if cerr := h.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// startHandler registers a new inbound stream handler. It returns false if the
+// host is shutting down.
+func (h *BasicHost) startHandler() bool {
+	h.drainMx.Lock()
+	defer h.drainMx.Unlock()
+	if h.draining {
+		return false
+	}
+	h.handlers.Add(1)
+	return true
+}
+
+func (h *BasicHost) isDraining() bool {
+	h.drainMx.Lock()
+	defer h.drainMx.Unlock()
+	return h.draining
+}
+
+// drainNotifiee closes new inbound connections once the host is shutting
+// down.
+func (h *BasicHost) drainNotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			if c.Stat().Direction == network.DirInbound && h.isDraining() {
+				log.Debugf("shutting down; closing inbound connection from %s", c.RemotePeer())
+				go c.Close()
+			}
+		},
+	}
+}
@@ -16,6 +16,7 @@ import (
 	logging "github.com/ipfs/go-log"
 	circuit "github.com/libp2p/go-libp2p-circuit"
 	lgbl "github.com/libp2p/go-libp2p-loggables"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -188,8 +189,30 @@ func (rh *RoutedHost) Close() error {
 	// no need to close IpfsRouting. we dont own it.
 	return rh.host.Close()
 }
+
+// Shutdown gracefully shuts the underlying host down if it supports it (see
+// basichost.BasicHost.Shutdown) and closes it otherwise.
+func (rh *RoutedHost) Shutdown(ctx context.Context) error {
+	if s, ok := rh.host.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return rh.host.Close()
+}
 func (rh *RoutedHost) ConnManager() connmgr.ConnManager {
 	return rh.host.ConnManager()
 }
 
+// Describe describes the underlying host. It returns nil if the underlying
+// host doesn't implement basichost.Describer.
+func (rh *RoutedHost) Describe() *basichost.HostDescription {
+	d, ok := rh.host.(basichost.Describer)
+	if !ok {
+		return nil
+	}
+	desc := d.Describe()
+	desc.Routed = true
+	return desc
+}
+
 var _ (host.Host) = (*RoutedHost)(nil)
+var _ (basichost.Describer) = (*RoutedHost)(nil)
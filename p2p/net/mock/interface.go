@@ -29,6 +29,14 @@ type Mocknet interface {
 	AddPeer(ic.PrivKey, ma.Multiaddr) (host.Host, error)
 	AddPeerWithPeerstore(peer.ID, peerstore.Peerstore) (host.Host, error)
 
+	// AddPeerNetwork adds a peer's network.Network without a host. It can be
+	// passed to the libp2p.Network option to build a full libp2p node on top
+	// of the Mocknet. Host returns nil for such peers.
+	//
+	// Mock streams don't support deadlines so such nodes must be built with
+	// the negotiation timeout disabled (libp2p.NegotiationTimeout(-1)).
+	AddPeerNetwork(peer.ID, peerstore.Peerstore) (network.Network, error)
+
 	// retrieve things (with randomized iteration order)
 	Peers() []peer.ID
 	Net(peer.ID) network.Network
@@ -31,6 +31,8 @@ type conn struct {
 	proc    process.Process
 	stat    network.Stat
 
+	closeOnce sync.Once
+
 	sync.RWMutex
 }
 
@@ -50,7 +52,11 @@ func newConn(ln, rn *peernet, l *link, dir network.Direction) *conn {
 	return c
 }
 
+// Close closes both sides of the connection.
 func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		go c.rconn.Close()
+	})
 	return c.proc.Close()
 }
 
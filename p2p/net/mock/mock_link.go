@@ -33,15 +33,15 @@ func (l *link) newConnPair(dialer *peernet) (*conn, *conn) {
 	l.RLock()
 	defer l.RUnlock()
 
-	c1 := newConn(l.nets[0], l.nets[1], l, network.DirOutbound)
-	c2 := newConn(l.nets[1], l.nets[0], l, network.DirInbound)
+	target := l.nets[0]
+	if target == dialer {
+		target = l.nets[1]
+	}
+	c1 := newConn(dialer, target, l, network.DirOutbound)
+	c2 := newConn(target, dialer, l, network.DirInbound)
 	c1.rconn = c2
 	c2.rconn = c1
-
-	if dialer == c1.net {
-		return c1, c2
-	}
-	return c2, c1
+	return c1, c2
 }
 
 func (l *link) newStreamPair() (*stream, *stream) {
@@ -121,6 +121,20 @@ func (mn *mocknet) AddPeerWithPeerstore(p peer.ID, ps peerstore.Peerstore) (host
 	return h, nil
 }
 
+func (mn *mocknet) AddPeerNetwork(p peer.ID, ps peerstore.Peerstore) (network.Network, error) {
+	n, err := newPeernet(mn.ctx, mn, p, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	mn.proc.AddChild(n.proc)
+
+	mn.Lock()
+	mn.nets[n.peer] = n
+	mn.Unlock()
+	return n, nil
+}
+
 func (mn *mocknet) Peers() []peer.ID {
 	mn.Lock()
 	defer mn.Unlock()
@@ -119,6 +119,61 @@ func (pn *peernet) DialPeer(ctx context.Context, p peer.ID) (network.Conn, error
 	return pn.connect(p)
 }
 
+// DialAddr opens a new connection to the peer on the given address, which must
+// be one of the peer's listen addresses.
+func (pn *peernet) DialAddr(ctx context.Context, p peer.ID, addr ma.Multiaddr) (network.Conn, error) {
+	if p == pn.peer {
+		return nil, fmt.Errorf("attempted to dial self %s", p)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rn, ok := pn.mocknet.Net(p).(*peernet)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot connect to %s", pn.peer, p)
+	}
+	reachable := false
+	for _, a := range rn.ps.Addrs(p) {
+		if a.Equal(addr) {
+			reachable = true
+			break
+		}
+	}
+	if !reachable {
+		return nil, fmt.Errorf("%s cannot connect to %s at %s", pn.peer, p, addr)
+	}
+
+	links := pn.mocknet.LinksBetweenPeers(pn.peer, p)
+	if len(links) < 1 {
+		return nil, fmt.Errorf("%s cannot connect to %s", pn.peer, p)
+	}
+	l := links[rand.Intn(len(links))].(*link)
+
+	log.Debugf("%s dialing %s at %s", pn.peer, p, addr)
+	lc, rc := l.newConnPair(pn)
+	lc.remoteAddr = addr
+	rc.localAddr = addr
+	// the connection is direct, so it doesn't originate from one of our
+	// relay addresses.
+	if local := directAddr(pn.ps.Addrs(pn.peer)); local != nil {
+		lc.localAddr = local
+		rc.remoteAddr = local
+	}
+	pn.addOpenedConn(lc, rc)
+	return lc, nil
+}
+
+// directAddr returns the first of the addresses that isn't a relay address.
+func directAddr(addrs []ma.Multiaddr) ma.Multiaddr {
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(ma.P_CIRCUIT); err != nil {
+			return a
+		}
+	}
+	return nil
+}
+
 func (pn *peernet) connect(p peer.ID) (*conn, error) {
 	if p == pn.peer {
 		return nil, fmt.Errorf("attempted to dial self %s", p)
@@ -159,13 +214,18 @@ func (pn *peernet) connect(p peer.ID) (*conn, error) {
 
 func (pn *peernet) openConn(r peer.ID, l *link) *conn {
 	lc, rc := l.newConnPair(pn)
+	pn.addOpenedConn(lc, rc)
+	return lc
+}
+
+// addOpenedConn adds a new connection pair dialed by this peer.
+func (pn *peernet) addOpenedConn(lc, rc *conn) {
 	log.Debugf("%s opening connection to %s", pn.LocalPeer(), lc.RemotePeer())
 	pn.addConn(lc)
 	pn.notifyAll(func(n network.Notifiee) {
 		n.Connected(pn, lc)
 	})
 	rc.net.remoteOpenedConn(rc)
-	return lc
 }
 
 func (pn *peernet) remoteOpenedConn(c *conn) {
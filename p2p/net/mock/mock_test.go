@@ -15,10 +15,13 @@ import (
 	"github.com/libp2p/go-libp2p-core/helpers"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-libp2p-core/test"
 	"github.com/libp2p/go-libp2p-testing/ci"
 	tnet "github.com/libp2p/go-libp2p-testing/net"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 func randPeer(t *testing.T) peer.ID {
@@ -658,3 +661,135 @@ func TestStreamsWithLatency(t *testing.T) {
 		t.Fatalf("Expected write to take ~%s (+/- %s), but took %s", latency.String(), tolerance.String(), delta.String())
 	}
 }
+
+func TestDialAddr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := FullMeshLinked(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	h1, h2 := hosts[0], hosts[1]
+	addr := h2.Addrs()[0]
+
+	c, err := mn.Net(h1.ID()).(*peernet).DialAddr(ctx, h2.ID(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.RemoteMultiaddr().Equal(addr) {
+		t.Errorf("expected the connection to be on %s, got %s", addr, c.RemoteMultiaddr())
+	}
+	rcs := h2.Network().ConnsToPeer(h1.ID())
+	if len(rcs) != 1 || !rcs[0].LocalMultiaddr().Equal(addr) {
+		t.Errorf("expected the remote connection to be on %s", addr)
+	}
+
+	_, err = mn.Net(h1.ID()).(*peernet).DialAddr(ctx, h2.ID(), ma.StringCast("/ip4/1.2.3.4/tcp/1"))
+	if err == nil {
+		t.Error("expected dialing an address the peer doesn't listen on to fail")
+	}
+
+	// hosts on mocknet rank dials
+	h1.Network().ClosePeer(h2.ID())
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID()}); err != nil {
+		t.Fatal(err)
+	}
+	// generated peers listen on public IPv6 addresses
+	if wins := h1.(*bhost.BasicHost).DialStats().Wins[bhost.AddrClassPublicIPv6]; wins != 1 {
+		t.Errorf("expected 1 public IPv6 win, got %d", wins)
+	}
+}
+
+func TestRankedDialSingleConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := FullMeshLinked(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	h1, h2 := hosts[0], hosts[1]
+
+	// both private addresses are dialed at once and succeed
+	for _, a := range []ma.Multiaddr{
+		ma.StringCast("/ip4/192.168.1.2/tcp/1"),
+		ma.StringCast("/ip4/192.168.1.3/tcp/1"),
+	} {
+		h2.Peerstore().AddAddr(h2.ID(), a, peerstore.PermanentAddrTTL)
+		h1.Peerstore().AddAddr(h2.ID(), a, peerstore.PermanentAddrTTL)
+	}
+
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID()}); err != nil {
+		t.Fatal(err)
+	}
+	// the losing dials are closed asynchronously
+	time.Sleep(100 * time.Millisecond)
+	if conns := h1.Network().ConnsToPeer(h2.ID()); len(conns) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(conns))
+	}
+}
+
+func TestConnDirection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := FullMeshLinked(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nets := mn.Nets()
+	// both peers of the link dial
+	for _, ns := range [][2]network.Network{{nets[0], nets[1]}, {nets[1], nets[0]}} {
+		c, err := ns[0].DialPeer(ctx, ns[1].LocalPeer())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir := c.Stat().Direction; dir != network.DirOutbound {
+			t.Errorf("expected the dialed connection to be outbound, got %d", dir)
+		}
+		for _, rc := range ns[1].ConnsToPeer(ns[0].LocalPeer()) {
+			if dir := rc.Stat().Direction; dir != network.DirInbound {
+				t.Errorf("expected the accepted connection to be inbound, got %d", dir)
+			}
+		}
+		c.Close()
+		for len(ns[1].ConnsToPeer(ns[0].LocalPeer())) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestConnCloseClosesRemote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nets := mn.Nets()
+	n1, n2 := nets[0], nets[1]
+
+	conns := n1.ConnsToPeer(n2.LocalPeer())
+	if len(conns) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(conns))
+	}
+	if err := conns[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the remote side learns about the close asynchronously, like it does
+	// on real transports.
+	for i := 0; len(n2.ConnsToPeer(n1.LocalPeer())) > 0; i++ {
+		if i > 100 {
+			t.Fatal("expected the remote side of the connection to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n2.Connectedness(n1.LocalPeer()) == network.Connected {
+		t.Error("expected the remote peer to be disconnected")
+	}
+}
@@ -0,0 +1,246 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultMinTTL is the default minimum time a Cache keeps records.
+	DefaultMinTTL = 5 * time.Second
+
+	// DefaultMaxTTL is the default maximum time a Cache keeps records.
+	DefaultMaxTTL = time.Hour
+
+	// DefaultMaxEntries is the default maximum number of record sets a Cache
+	// keeps.
+	DefaultMaxEntries = 1024
+)
+
+type config struct {
+	fallbackTTL time.Duration
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	maxEntries  int
+}
+
+// Option is an option of NewCache.
+type Option func(*config)
+
+// FallbackTTL sets how long the records of backends not reporting TTLs are
+// cached. By default, they're only cached for the minimum TTL (see MinTTL), as
+// their actual TTLs are unknown.
+func FallbackTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.fallbackTTL = ttl
+	}
+}
+
+// MinTTL sets the minimum time records are cached, regardless of their TTL.
+func MinTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.minTTL = ttl
+	}
+}
+
+// MaxTTL sets the maximum time records are cached, regardless of their TTL.
+func MaxTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.maxTTL = ttl
+	}
+}
+
+// MaxEntries sets the maximum number of record sets (the records of a type for
+// a name) cached. Once it's reached, the records expiring first are evicted.
+func MaxEntries(n int) Option {
+	return func(cfg *config) {
+		cfg.maxEntries = n
+	}
+}
+
+type recordType int
+
+const (
+	typeIP recordType = iota
+	typeTXT
+)
+
+type cacheKey struct {
+	typ  recordType
+	name string
+}
+
+type entry struct {
+	ips     []net.IPAddr
+	txts    []string
+	expires time.Time
+}
+
+// Cache is a Backend caching the records returned by another backend. Records
+// are cached for their TTL if the backend is a TTLBackend, and for the
+// fallback TTL otherwise. Failed lookups aren't cached.
+//
+// The system resolver (net.DefaultResolver) doesn't report TTLs, so its
+// records are only cached for the minimum TTL unless a fallback TTL is set.
+type Cache struct {
+	backend Backend
+	cfg     config
+	now     func() time.Time
+
+	mx      sync.Mutex
+	entries map[cacheKey]*entry
+}
+
+var _ TTLBackend = (*Cache)(nil)
+
+// NewCache constructs a cache of the given backend's records.
+func NewCache(backend Backend, opts ...Option) *Cache {
+	cfg := config{
+		minTTL:      DefaultMinTTL,
+		maxTTL:      DefaultMaxTTL,
+		maxEntries:  DefaultMaxEntries,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Cache{
+		backend: backend,
+		cfg:     cfg,
+		now:     time.Now,
+		entries: make(map[cacheKey]*entry),
+	}
+}
+
+// LookupIPAddr implements Backend.
+func (c *Cache) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	ips, _, err := c.LookupIPAddrTTL(ctx, name)
+	return ips, err
+}
+
+// LookupTXT implements Backend.
+func (c *Cache) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txts, _, err := c.LookupTXTTTL(ctx, name)
+	return txts, err
+}
+
+// LookupIPAddrTTL implements TTLBackend, returning the time left until the
+// records expire from the cache.
+func (c *Cache) LookupIPAddrTTL(ctx context.Context, name string) ([]net.IPAddr, time.Duration, error) {
+	key := cacheKey{typeIP, name}
+	if e, ttl := c.get(key); e != nil {
+		return e.ips, ttl, nil
+	}
+
+	var (
+		ips []net.IPAddr
+		ttl = c.cfg.fallbackTTL
+		err error
+	)
+	if tb, ok := c.backend.(TTLBackend); ok {
+		ips, ttl, err = tb.LookupIPAddrTTL(ctx, name)
+	} else {
+		ips, err = c.backend.LookupIPAddr(ctx, name)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return ips, c.put(key, &entry{ips: ips}, ttl), nil
+}
+
+// LookupTXTTTL implements TTLBackend, returning the time left until the
+// records expire from the cache.
+func (c *Cache) LookupTXTTTL(ctx context.Context, name string) ([]string, time.Duration, error) {
+	key := cacheKey{typeTXT, name}
+	if e, ttl := c.get(key); e != nil {
+		return e.txts, ttl, nil
+	}
+
+	var (
+		txts []string
+		ttl  = c.cfg.fallbackTTL
+		err  error
+	)
+	if tb, ok := c.backend.(TTLBackend); ok {
+		txts, ttl, err = tb.LookupTXTTTL(ctx, name)
+	} else {
+		txts, err = c.backend.LookupTXT(ctx, name)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return txts, c.put(key, &entry{txts: txts}, ttl), nil
+}
+
+// Flush drops all cached records.
+func (c *Cache) Flush() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.entries = make(map[cacheKey]*entry)
+}
+
+// get returns the unexpired entry, if any, and the time left until it
+// expires.
+func (c *Cache) get(key cacheKey) (*entry, time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, 0
+	}
+	ttl := e.expires.Sub(c.now())
+	if ttl <= 0 {
+		delete(c.entries, key)
+		return nil, 0
+	}
+	return e, ttl
+}
+
+// put caches the entry for the given TTL, clamped to the configured bounds,
+// and returns the clamped TTL.
+func (c *Cache) put(key cacheKey, e *entry, ttl time.Duration) time.Duration {
+	if ttl < c.cfg.minTTL {
+		ttl = c.cfg.minTTL
+	}
+	if ttl > c.cfg.maxTTL {
+		ttl = c.cfg.maxTTL
+	}
+	if ttl <= 0 || c.cfg.maxEntries <= 0 {
+		return 0
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := c.now()
+	e.expires = now.Add(ttl)
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = e
+	return ttl
+}
+
+// evict drops the expired entries or, if there are none, the entry expiring
+// first. It must be called with the lock held.
+func (c *Cache) evict(now time.Time) {
+	var first cacheKey
+	var firstEntry *entry
+	evicted := false
+	for key, e := range c.entries {
+		if !e.expires.After(now) {
+			delete(c.entries, key)
+			evicted = true
+			continue
+		}
+		if firstEntry == nil || e.expires.Before(firstEntry.expires) {
+			first, firstEntry = key, e
+		}
+	}
+	if !evicted && firstEntry != nil {
+		log.Debugf("cache full, evicting the records of %s", first.name)
+		delete(c.entries, first)
+	}
+}
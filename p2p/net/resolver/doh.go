@@ -0,0 +1,176 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dohMediaType = "application/dns-message"
+
+	// maxDoHResponseSize is the maximum size of a DNS message.
+	maxDoHResponseSize = 64 << 10
+)
+
+// DoH is a TTLBackend resolving names with DNS over HTTPS (RFC 8484).
+type DoH struct {
+	// URL is the URL of the DNS over HTTPS endpoint, e.g.,
+	// "https://cloudflare-dns.com/dns-query".
+	URL string
+	// Client sends the queries. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+var _ TTLBackend = (*DoH)(nil)
+
+// LookupIPAddr implements Backend.
+func (d *DoH) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	ips, _, err := d.LookupIPAddrTTL(ctx, name)
+	return ips, err
+}
+
+// LookupTXT implements Backend.
+func (d *DoH) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txts, _, err := d.LookupTXTTTL(ctx, name)
+	return txts, err
+}
+
+// LookupIPAddrTTL implements TTLBackend, querying the A and AAAA records of
+// the name. The returned TTL is the lowest TTL of the records.
+func (d *DoH) LookupIPAddrTTL(ctx context.Context, name string) ([]net.IPAddr, time.Duration, error) {
+	type result struct {
+		rrs []dns.RR
+		ttl time.Duration
+		err error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		go func(qtype uint16) {
+			rrs, ttl, err := d.query(ctx, name, qtype)
+			results <- result{rrs, ttl, err}
+		}(qtype)
+	}
+
+	var (
+		ips []net.IPAddr
+		ttl time.Duration = -1
+		err error
+	)
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		if ttl < 0 || res.ttl < ttl {
+			ttl = res.ttl
+		}
+		for _, rr := range res.rrs {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, net.IPAddr{IP: rr.A})
+			case *dns.AAAA:
+				ips = append(ips, net.IPAddr{IP: rr.AAAA})
+			}
+		}
+	}
+	// like net.Resolver, only fail if both queries failed.
+	if ttl < 0 {
+		return nil, 0, err
+	}
+	return ips, ttl, nil
+}
+
+// LookupTXTTTL implements TTLBackend. The returned TTL is the lowest TTL of
+// the records.
+func (d *DoH) LookupTXTTTL(ctx context.Context, name string) ([]string, time.Duration, error) {
+	rrs, ttl, err := d.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, 0, err
+	}
+	var txts []string
+	for _, rr := range rrs {
+		if txt, ok := rr.(*dns.TXT); ok {
+			// like net.Resolver, join the strings of a record.
+			txts = append(txts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return txts, ttl, nil
+}
+
+// query sends a query and returns the answer records and their lowest TTL.
+// Empty answers are valid for the negative caching TTL of the zone, if any.
+func (d *DoH) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, time.Duration, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), qtype)
+	// RFC 8484 recommends an ID of 0 for cacheability.
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("querying %s: unexpected status %s", d.URL, resp.Status)
+	}
+	rbody, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxDoHResponseSize})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var r dns.Msg
+	if err := r.Unpack(rbody); err != nil {
+		return nil, 0, fmt.Errorf("parsing response from %s: %s", d.URL, err)
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, Server: d.URL}
+	default:
+		return nil, 0, &net.DNSError{Err: dns.RcodeToString[r.Rcode], Name: name, Server: d.URL}
+	}
+
+	var ttl uint32
+	for i, rr := range r.Answer {
+		if h := rr.Header(); i == 0 || h.Ttl < ttl {
+			ttl = h.Ttl
+		}
+	}
+	if len(r.Answer) == 0 {
+		for _, rr := range r.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = soa.Minttl
+				if soa.Hdr.Ttl < ttl {
+					ttl = soa.Hdr.Ttl
+				}
+			}
+		}
+	}
+	return r.Answer, time.Duration(ttl) * time.Second, nil
+}
@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohServer answers DNS over HTTPS queries from the given zone.
+func dohServer(t *testing.T, zone map[uint16][]dns.RR) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var q dns.Msg
+		if err := q.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(&q)
+		if q.Question[0].Name != "example.com." {
+			resp.Rcode = dns.RcodeNameError
+		}
+		resp.Answer = zone[q.Question[0].Qtype]
+		out, err := resp.Pack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(out)
+	}))
+}
+
+func rr(t *testing.T, s string) dns.RR {
+	r, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDoH(t *testing.T) {
+	ctx := context.Background()
+	srv := dohServer(t, map[uint16][]dns.RR{
+		dns.TypeA: {
+			rr(t, "example.com. 300 IN A 192.0.2.1"),
+			rr(t, "example.com. 60 IN A 192.0.2.2"),
+		},
+		dns.TypeAAAA: {rr(t, "example.com. 120 IN AAAA 2001:db8::1")},
+		dns.TypeTXT: {
+			rr(t, `example.com. 30 IN TXT "dnsaddr=/ip4/192.0.2.1" "/tcp/1"`),
+		},
+	})
+	defer srv.Close()
+	d := &DoH{URL: srv.URL}
+
+	ips, ttl, err := d.LookupIPAddrTTL(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 3 {
+		t.Errorf("expected 3 addresses, got %v", ips)
+	}
+	if ttl != time.Minute {
+		t.Errorf("expected the lowest TTL, got %s", ttl)
+	}
+
+	txts, ttl, err := d.LookupTXTTTL(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txts) != 1 || txts[0] != "dnsaddr=/ip4/192.0.2.1/tcp/1" {
+		t.Errorf("expected the joined record, got %q", txts)
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("expected a TTL of 30s, got %s", ttl)
+	}
+
+	_, err = d.LookupIPAddr(ctx, "missing.example.com")
+	if derr, ok := err.(*net.DNSError); !ok || derr.Err != "no such host" {
+		t.Errorf("expected a no such host error, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Hosts is a Backend resolving names from static records, like a hosts file.
+// Names without static records are resolved by the fallback backend, if any.
+//
+// Names are matched case insensitively and regardless of a trailing dot.
+type Hosts struct {
+	// IP maps names to their IP addresses.
+	IP map[string][]net.IPAddr
+	// TXT maps names to their TXT records, e.g., "_dnsaddr.example.com" to
+	// "dnsaddr=/ip4/...".
+	TXT map[string][]string
+
+	// Fallback resolves the names without static records. If nil, looking
+	// them up fails.
+	Fallback Backend
+}
+
+// LookupIPAddr implements Backend.
+func (h *Hosts) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	key := normalizeName(name)
+	for n, ips := range h.IP {
+		if normalizeName(n) == key {
+			return ips, nil
+		}
+	}
+	if h.Fallback == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	return h.Fallback.LookupIPAddr(ctx, name)
+}
+
+// LookupTXT implements Backend.
+func (h *Hosts) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	key := normalizeName(name)
+	for n, txts := range h.TXT {
+		if normalizeName(n) == key {
+			return txts, nil
+		}
+	}
+	if h.Fallback == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	return h.Fallback.LookupTXT(ctx, name)
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
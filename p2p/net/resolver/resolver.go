@@ -0,0 +1,38 @@
+// Package resolver provides DNS backends for resolving multiaddrs with
+// madns.Resolver: a cache honoring the record TTLs of backends reporting them,
+// static host records and DNS over HTTPS.
+//
+// Backends compose, e.g., static records in front of a cached DNS over HTTPS
+// resolver:
+//
+//	backend := &resolver.Hosts{
+//	    IP:       map[string][]net.IPAddr{"node.example.com": ...},
+//	    Fallback: resolver.NewCache(&resolver.DoH{URL: "https://1.1.1.1/dns-query"}),
+//	}
+//	r := &madns.Resolver{Backend: backend}
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("resolver")
+
+// Backend resolves DNS names. It's the interface of the backends of
+// madns.Resolver and is implemented by *net.Resolver.
+type Backend interface {
+	LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// TTLBackend is a Backend also reporting how long the records it returns may
+// be cached.
+type TTLBackend interface {
+	Backend
+	LookupIPAddrTTL(ctx context.Context, name string) ([]net.IPAddr, time.Duration, error)
+	LookupTXTTTL(ctx context.Context, name string) ([]string, time.Duration, error)
+}
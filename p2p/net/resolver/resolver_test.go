@@ -0,0 +1,188 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// countingBackend returns the same records for every name and counts the
+// lookups.
+type countingBackend struct {
+	ttl     time.Duration
+	fail    bool
+	lookups int
+}
+
+func (b *countingBackend) LookupIPAddr(ctx context.Context, name string) ([]net.IPAddr, error) {
+	ips, _, err := b.LookupIPAddrTTL(ctx, name)
+	return ips, err
+}
+
+func (b *countingBackend) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txts, _, err := b.LookupTXTTTL(ctx, name)
+	return txts, err
+}
+
+func (b *countingBackend) LookupIPAddrTTL(ctx context.Context, name string) ([]net.IPAddr, time.Duration, error) {
+	b.lookups++
+	if b.fail {
+		return nil, 0, errors.New("lookup failed")
+	}
+	return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, b.ttl, nil
+}
+
+func (b *countingBackend) LookupTXTTTL(ctx context.Context, name string) ([]string, time.Duration, error) {
+	b.lookups++
+	if b.fail {
+		return nil, 0, errors.New("lookup failed")
+	}
+	return []string{"dnsaddr=/ip4/192.0.2.1/tcp/1"}, b.ttl, nil
+}
+
+// ttlless hides the TTL methods of a backend.
+type ttlless struct{ Backend }
+
+func TestCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backend := &countingBackend{ttl: 30 * time.Second}
+	c := NewCache(backend)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.LookupIPAddr(ctx, "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.lookups != 1 {
+		t.Fatalf("expected 1 lookup, got %d", backend.lookups)
+	}
+	// record types are cached separately
+	if _, err := c.LookupTXT(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if backend.lookups != 2 {
+		t.Fatalf("expected 2 lookups, got %d", backend.lookups)
+	}
+
+	now = now.Add(20 * time.Second)
+	if _, ttl, _ := c.LookupIPAddrTTL(ctx, "example.com"); ttl != 10*time.Second {
+		t.Errorf("expected 10s left, got %s", ttl)
+	}
+	now = now.Add(10 * time.Second)
+	if _, err := c.LookupIPAddr(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if backend.lookups != 3 {
+		t.Fatalf("expected the expired records to be looked up, got %d lookups", backend.lookups)
+	}
+
+	c.Flush()
+	if _, err := c.LookupTXT(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if backend.lookups != 4 {
+		t.Fatalf("expected the flushed records to be looked up, got %d lookups", backend.lookups)
+	}
+}
+
+func TestCacheTTLBounds(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingBackend{}
+
+	for _, tc := range []struct {
+		backend  Backend
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		{backend, time.Second, 5 * time.Second},
+		{backend, 2 * time.Hour, time.Hour},
+		{ttlless{backend}, time.Second, 2 * time.Minute},
+	} {
+		backend.ttl = tc.ttl
+		c := NewCache(tc.backend, MinTTL(5*time.Second), MaxTTL(time.Hour), FallbackTTL(2*time.Minute))
+		if _, ttl, err := c.LookupIPAddrTTL(ctx, "example.com"); err != nil || ttl != tc.expected {
+			t.Errorf("expected a TTL of %s, got %s (%v)", tc.expected, ttl, err)
+		}
+	}
+}
+
+func TestCacheNoFallbackTTL(t *testing.T) {
+	// records of backends not reporting TTLs are cached for the minimum TTL.
+	c := NewCache(ttlless{&countingBackend{ttl: time.Hour}}, MinTTL(10*time.Second))
+	if _, ttl, err := c.LookupIPAddrTTL(context.Background(), "example.com"); err != nil || ttl != 10*time.Second {
+		t.Errorf("expected a TTL of 10s, got %s (%v)", ttl, err)
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingBackend{ttl: time.Minute, fail: true}
+	c := NewCache(backend)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.LookupIPAddr(ctx, "example.com"); err == nil {
+			t.Fatal("expected the lookup to fail")
+		}
+	}
+	if backend.lookups != 2 {
+		t.Fatalf("expected failures not to be cached, got %d lookups", backend.lookups)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backend := &countingBackend{ttl: time.Minute}
+	c := NewCache(backend, MaxEntries(2))
+	c.now = func() time.Time { return now }
+
+	c.LookupIPAddr(ctx, "a.example.com")
+	now = now.Add(time.Second)
+	c.LookupIPAddr(ctx, "b.example.com")
+	c.LookupIPAddr(ctx, "c.example.com")
+	if len(c.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(c.entries))
+	}
+	if _, ok := c.entries[cacheKey{typeIP, "a.example.com"}]; ok {
+		t.Error("expected the entry expiring first to be evicted")
+	}
+}
+
+func TestHosts(t *testing.T) {
+	ctx := context.Background()
+	ip := net.IPv4(192, 0, 2, 2)
+	fallback := &countingBackend{ttl: time.Minute}
+	h := &Hosts{
+		IP:       map[string][]net.IPAddr{"Node.example.com": {{IP: ip}}},
+		TXT:      map[string][]string{"_dnsaddr.example.com": {"dnsaddr=/ip4/192.0.2.2/tcp/1"}},
+		Fallback: fallback,
+	}
+
+	ips, err := h.LookupIPAddr(ctx, "node.example.com.")
+	if err != nil || len(ips) != 1 || !ips[0].IP.Equal(ip) {
+		t.Fatalf("expected the static address, got %v (%v)", ips, err)
+	}
+	txts, err := h.LookupTXT(ctx, "_dnsaddr.example.com")
+	if err != nil || len(txts) != 1 || txts[0] != "dnsaddr=/ip4/192.0.2.2/tcp/1" {
+		t.Fatalf("expected the static record, got %v (%v)", txts, err)
+	}
+	if fallback.lookups != 0 {
+		t.Fatalf("expected no fallback lookups, got %d", fallback.lookups)
+	}
+
+	if _, err := h.LookupIPAddr(ctx, "other.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if fallback.lookups != 1 {
+		t.Fatalf("expected a fallback lookup, got %d", fallback.lookups)
+	}
+
+	h.Fallback = nil
+	if _, err := h.LookupIPAddr(ctx, "other.example.com"); err == nil {
+		t.Fatal("expected the lookup to fail without fallback")
+	}
+}
@@ -0,0 +1,185 @@
+package identify
+
+import (
+	"fmt"
+	"io"
+
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+
+	ggio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
+)
+
+// frameSize is the size identify messages are split at. It's the largest
+// message peers running older versions accept; they only read the first
+// frame of a message.
+const frameSize = 2048
+
+// defaultMaxMessageSize is the default value of the MaxMessageSize option.
+const defaultMaxMessageSize = 8 << 10
+
+// moreSize is the size the more flag adds to a frame.
+var moreSize = (&pb.Identify{More: proto.Bool(true)}).Size()
+
+// writeMessage writes the message, split into frames of at most frameSize
+// bytes if it's larger.
+func writeMessage(w io.Writer, mes *pb.Identify) error {
+	return writeFrames(w, splitMessage(mes, frameSize))
+}
+
+func writeFrames(w io.Writer, frames []*pb.Identify) error {
+	dw := ggio.NewDelimitedWriter(w)
+	for _, frame := range frames {
+		if err := dw.WriteMsg(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMessage reads the frames of a message up to the first one without the
+// more flag, and merges them into mes. It doesn't wait for the stream to be
+// closed, as peers running older versions send a single frame and leave the
+// stream open. The frames may not exceed the maximum message size in total.
+func (ids *IDService) readMessage(r io.Reader, mes *pb.Identify) error {
+	dr := ggio.NewDelimitedReader(r, ids.maxMessageSize)
+	size := 0
+	for i := 0; ; i++ {
+		var frame pb.Identify
+		err := dr.ReadMsg(&frame)
+		switch {
+		case err == io.EOF && i > 0:
+			return io.ErrUnexpectedEOF
+		case err == io.ErrShortBuffer:
+			return fmt.Errorf("identify message larger than %d bytes", ids.maxMessageSize)
+		case err != nil:
+			return err
+		}
+
+		size += frame.Size()
+		if size > ids.maxMessageSize {
+			return fmt.Errorf("identify message larger than %d bytes", ids.maxMessageSize)
+		}
+		more := frame.GetMore()
+		frame.More = nil
+		proto.Merge(mes, &frame)
+		if !more {
+			return nil
+		}
+	}
+}
+
+// splitMessage splits the message into frames of at most the given size,
+// which merge back into the message. The first frame carries all singular
+// fields, and as many values of the repeated fields as fit; the others carry
+// the remaining values. Frames only exceed the size if a single value or the
+// singular fields do. All frames but the last one have the more flag set.
+func splitMessage(mes *pb.Identify, size int) []*pb.Identify {
+	if mes.Size() <= size {
+		return []*pb.Identify{mes}
+	}
+
+	frames := splitFields(mes, size-moreSize)
+	for _, f := range frames[:len(frames)-1] {
+		f.More = proto.Bool(true)
+	}
+	return frames
+}
+
+// splitFields distributes the fields of the message over frames of at most
+// the given size.
+func splitFields(mes *pb.Identify, size int) []*pb.Identify {
+	first := &pb.Identify{
+		ProtocolVersion:  mes.ProtocolVersion,
+		AgentVersion:     mes.AgentVersion,
+		PublicKey:        mes.PublicKey,
+		ObservedAddr:     mes.ObservedAddr,
+		SignedPeerRecord: mes.SignedPeerRecord,
+	}
+	if d := mes.Delta; d != nil {
+		first.Delta = &pb.Delta{
+			AgentVersion:     d.AgentVersion,
+			ProtocolVersion:  d.ProtocolVersion,
+			SignedPeerRecord: d.SignedPeerRecord,
+		}
+	}
+	sp := &splitter{size: size, frames: []*pb.Identify{first}}
+
+	for _, a := range mes.ListenAddrs {
+		a := a
+		sp.add(
+			func(f *pb.Identify) { f.ListenAddrs = append(f.ListenAddrs, a) },
+			func(f *pb.Identify) { f.ListenAddrs = f.ListenAddrs[:len(f.ListenAddrs)-1] },
+		)
+	}
+	for _, p := range mes.Protocols {
+		p := p
+		sp.add(
+			func(f *pb.Identify) { f.Protocols = append(f.Protocols, p) },
+			func(f *pb.Identify) { f.Protocols = f.Protocols[:len(f.Protocols)-1] },
+		)
+	}
+
+	d := mes.Delta
+	if d == nil {
+		return sp.frames
+	}
+	for _, a := range d.AddedAddrs {
+		a := a
+		sp.add(
+			func(f *pb.Identify) { delta(f).AddedAddrs = append(delta(f).AddedAddrs, a) },
+			func(f *pb.Identify) { f.Delta.AddedAddrs = f.Delta.AddedAddrs[:len(f.Delta.AddedAddrs)-1] },
+		)
+	}
+	for _, a := range d.RmAddrs {
+		a := a
+		sp.add(
+			func(f *pb.Identify) { delta(f).RmAddrs = append(delta(f).RmAddrs, a) },
+			func(f *pb.Identify) { f.Delta.RmAddrs = f.Delta.RmAddrs[:len(f.Delta.RmAddrs)-1] },
+		)
+	}
+	for _, p := range d.AddedProtocols {
+		p := p
+		sp.add(
+			func(f *pb.Identify) { delta(f).AddedProtocols = append(delta(f).AddedProtocols, p) },
+			func(f *pb.Identify) { f.Delta.AddedProtocols = f.Delta.AddedProtocols[:len(f.Delta.AddedProtocols)-1] },
+		)
+	}
+	for _, p := range d.RmProtocols {
+		p := p
+		sp.add(
+			func(f *pb.Identify) { delta(f).RmProtocols = append(delta(f).RmProtocols, p) },
+			func(f *pb.Identify) { f.Delta.RmProtocols = f.Delta.RmProtocols[:len(f.Delta.RmProtocols)-1] },
+		)
+	}
+	return sp.frames
+}
+
+// splitter distributes the values of repeated fields over frames.
+type splitter struct {
+	size   int
+	frames []*pb.Identify
+}
+
+// add adds a value to the last frame with push, or to a new frame if the
+// last one gets too large. pop removes the value pushed last.
+func (sp *splitter) add(push, pop func(f *pb.Identify)) {
+	f := sp.frames[len(sp.frames)-1]
+	before := f.Size()
+	push(f)
+	if before == 0 || f.Size() <= sp.size {
+		return
+	}
+	pop(f)
+	f = &pb.Identify{}
+	sp.frames = append(sp.frames, f)
+	push(f)
+}
+
+// delta returns the delta of the frame, adding one if it has none.
+func delta(f *pb.Identify) *pb.Delta {
+	if f.Delta == nil {
+		f.Delta = &pb.Delta{}
+	}
+	return f.Delta
+}
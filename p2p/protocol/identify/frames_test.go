@@ -0,0 +1,136 @@
+package identify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+
+	"github.com/gogo/protobuf/proto"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSplitMessage(t *testing.T) {
+	var protos []string
+	var addrs [][]byte
+	for i := 0; i < 100; i++ {
+		protos = append(protos, fmt.Sprintf("/test/some/rather/long/protocol/%d", i))
+		addrs = append(addrs, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i)).Bytes())
+	}
+	pv, av := LibP2PVersion, "agent"
+
+	for _, mes := range []*pb.Identify{
+		{ProtocolVersion: &pv, AgentVersion: &av, Protocols: protos[:2], ListenAddrs: addrs[:2]},
+		{ProtocolVersion: &pv, AgentVersion: &av, Protocols: protos, ListenAddrs: addrs, SignedPeerRecord: make([]byte, 512)},
+		{Delta: &pb.Delta{AgentVersion: &av, AddedProtocols: protos, RmProtocols: protos[:50], AddedAddrs: addrs, RmAddrs: addrs[:50]}},
+	} {
+		frames := splitMessage(mes, 1024)
+		if mes.Size() <= 1024 && len(frames) != 1 {
+			t.Errorf("expected a small message not to be split, got %d frames", len(frames))
+		}
+
+		var buf bytes.Buffer
+		if err := writeFrames(&buf, frames); err != nil {
+			t.Fatal(err)
+		}
+		ids := &IDService{maxMessageSize: 2 * mes.Size()}
+		var merged pb.Identify
+		if err := ids.readMessage(&buf, &merged); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(&merged, mes) {
+			t.Errorf("expected the frames to merge into the message")
+		}
+
+		for i, f := range frames {
+			if f.Size() > 1024 {
+				t.Errorf("frame %d of %d is %d bytes", i, len(frames), f.Size())
+			}
+		}
+		if av := frames[0].GetAgentVersion() + frames[0].GetDelta().GetAgentVersion(); av != "agent" {
+			t.Errorf("expected the first frame to carry the agent version, got %q", av)
+		}
+	}
+}
+
+func TestReadMessageLimit(t *testing.T) {
+	var protos []string
+	for i := 0; i < 100; i++ {
+		protos = append(protos, fmt.Sprintf("/test/some/rather/long/protocol/%d", i))
+	}
+	mes := &pb.Identify{Protocols: protos}
+
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, mes); err != nil {
+		t.Fatal(err)
+	}
+	// every frame is within the limit, but not all of them.
+	ids := &IDService{maxMessageSize: frameSize}
+	var merged pb.Identify
+	if err := ids.readMessage(&buf, &merged); err == nil {
+		t.Fatal("expected the message to be rejected")
+	}
+}
+
+func TestReadMessageOpenStream(t *testing.T) {
+	var protos []string
+	for i := 0; i < 100; i++ {
+		protos = append(protos, fmt.Sprintf("/test/some/rather/long/protocol/%d", i))
+	}
+	av := "agent"
+	small := &pb.Identify{AgentVersion: &av}
+	large := &pb.Identify{AgentVersion: &av, Protocols: protos}
+
+	// the writer leaves the stream open after each message.
+	r, w := io.Pipe()
+	defer w.Close()
+	go func() {
+		for _, mes := range []*pb.Identify{small, large} {
+			if err := writeMessage(w, mes); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	ids := &IDService{maxMessageSize: defaultMaxMessageSize}
+	for _, mes := range []*pb.Identify{small, large} {
+		done := make(chan error, 1)
+		var read pb.Identify
+		go func() { done <- ids.readMessage(r, &read) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the message to be read without the stream being closed")
+		}
+		if !proto.Equal(&read, mes) {
+			t.Errorf("expected %v, got %v", mes, &read)
+		}
+	}
+}
+
+func TestReadMessageTruncated(t *testing.T) {
+	var protos []string
+	for i := 0; i < 100; i++ {
+		protos = append(protos, fmt.Sprintf("/test/some/rather/long/protocol/%d", i))
+	}
+	frames := splitMessage(&pb.Identify{Protocols: protos}, frameSize)
+	if len(frames) < 2 {
+		t.Fatalf("expected the message to be split, got %d frames", len(frames))
+	}
+
+	var buf bytes.Buffer
+	if err := writeFrames(&buf, frames[:len(frames)-1]); err != nil {
+		t.Fatal(err)
+	}
+	ids := &IDService{maxMessageSize: defaultMaxMessageSize}
+	var read pb.Identify
+	if err := ids.readMessage(&buf, &read); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the truncated message to be rejected, got %v", err)
+	}
+}
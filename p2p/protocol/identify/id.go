@@ -19,7 +19,6 @@ import (
 
 	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
 
-	ggio "github.com/gogo/protobuf/io"
 	logging "github.com/ipfs/go-log"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -59,6 +58,9 @@ func init() {
 // transientTTL is a short ttl for invalidated previously connected addrs
 const transientTTL = 10 * time.Second
 
+// defaultPushDebounce is the default value of the PushDebounce option.
+const defaultPushDebounce = 500 * time.Millisecond
+
 // IDService is a structure that implements ProtocolIdentify.
 // It is a trivial service that gives the other peer some
 // useful information about the local peer. A sort of hello.
@@ -67,11 +69,14 @@ const transientTTL = 10 * time.Second
 //  * Our IPFS Protocol Version
 //  * Our IPFS Agent Version
 //  * Our public Listen Addresses
+//  * A signed peer record certifying our Listen Addresses
 type IDService struct {
 	Host      host.Host
 	UserAgent string
 
-	ctx context.Context
+	ctx            context.Context
+	pushDebounce   time.Duration
+	maxMessageSize int
 
 	// connections undergoing identification
 	// for wait purposes
@@ -80,6 +85,16 @@ type IDService struct {
 
 	addrMu sync.Mutex
 
+	// what the connected peers told us about themselves.
+	infoMu sync.Mutex
+	infos  map[peer.ID]*PeerInfo
+
+	// our signed peer records, by whether they include loopback addresses,
+	// and the sequence number they're signed with.
+	recordMu  sync.Mutex
+	records   map[bool]*signedRecord
+	recordSeq uint64
+
 	// our own observed addresses.
 	// TODO: instead of expiring, remove these when we disconnect
 	observedAddrs *ObservedAddrSet
@@ -88,6 +103,7 @@ type IDService struct {
 	emitters     struct {
 		evtPeerProtocolsUpdated        event.Emitter
 		evtPeerIdentificationCompleted event.Emitter
+		evtPeerIdentified              event.Emitter
 		evtPeerIdentificationFailed    event.Emitter
 	}
 }
@@ -95,7 +111,10 @@ type IDService struct {
 // NewIDService constructs a new *IDService and activates it by
 // attaching its stream handler to the given host.Host.
 func NewIDService(ctx context.Context, h host.Host, opts ...Option) *IDService {
-	var cfg config
+	cfg := config{
+		pushDebounce:   defaultPushDebounce,
+		maxMessageSize: defaultMaxMessageSize,
+	}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
@@ -110,15 +129,21 @@ func NewIDService(ctx context.Context, h host.Host, opts ...Option) *IDService {
 		UserAgent: userAgent,
 
 		ctx:           ctx,
+		pushDebounce:   cfg.pushDebounce,
+		maxMessageSize: cfg.maxMessageSize,
 		currid:        make(map[network.Conn]chan struct{}),
+		infos:         make(map[peer.ID]*PeerInfo),
 		observedAddrs: NewObservedAddrSet(ctx),
 	}
 
-	// handle local protocol handler updates, and push deltas to peers.
+	// handle local protocol handler and address updates, and push deltas to peers.
 	var err error
-	s.subscription, err = h.EventBus().Subscribe(&event.EvtLocalProtocolsUpdated{}, eventbus.BufSize(128))
+	s.subscription, err = h.EventBus().Subscribe([]interface{}{
+		&event.EvtLocalProtocolsUpdated{},
+		&event.EvtLocalAddressesUpdated{},
+	}, eventbus.BufSize(128))
 	if err != nil {
-		log.Warningf("identify service not subscribed to local protocol handlers updates; err: %s", err)
+		log.Warningf("identify service not subscribed to local protocol handlers and address updates; err: %s", err)
 	} else {
 		go s.handleEvents()
 	}
@@ -131,6 +156,10 @@ func NewIDService(ctx context.Context, h host.Host, opts ...Option) *IDService {
 	if err != nil {
 		log.Warningf("identify service not emitting identification completed events; err: %s", err)
 	}
+	s.emitters.evtPeerIdentified, err = h.EventBus().Emitter(&EvtPeerIdentified{})
+	if err != nil {
+		log.Warningf("identify service not emitting identified peer info events; err: %s", err)
+	}
 	s.emitters.evtPeerIdentificationFailed, err = h.EventBus().Emitter(&event.EvtPeerIdentificationFailed{})
 	if err != nil {
 		log.Warningf("identify service not emitting identification failed events; err: %s", err)
@@ -139,6 +168,7 @@ func NewIDService(ctx context.Context, h host.Host, opts ...Option) *IDService {
 	h.SetStreamHandler(ID, s.requestHandler)
 	h.SetStreamHandler(IDPush, s.pushHandler)
 	h.SetStreamHandler(IDDelta, s.deltaHandler)
+	h.SetStreamHandler(IDDeltaLegacy, s.deltaHandler)
 	h.Network().Notify((*netNotifiee)(s))
 	return s
 }
@@ -152,13 +182,34 @@ func (ids *IDService) handleEvents() {
 		}
 	}()
 
+	// address changes are collected for pushDebounce before they're pushed.
+	var (
+		pending  pendingAddrs
+		pushC    <-chan time.Time
+		pushStop = func() bool { return false }
+	)
+	defer func() { pushStop() }()
+
 	for {
 		select {
 		case evt, more := <-sub.Out():
 			if !more {
 				return
 			}
-			ids.fireProtocolDelta(evt.(event.EvtLocalProtocolsUpdated))
+			switch evt := evt.(type) {
+			case event.EvtLocalProtocolsUpdated:
+				ids.fireProtocolDelta(evt)
+			case event.EvtLocalAddressesUpdated:
+				pending.update(evt)
+				if pushC == nil {
+					t := time.NewTimer(ids.pushDebounce)
+					pushC, pushStop = t.C, t.Stop
+				}
+			}
+		case <-pushC:
+			pushC = nil
+			pending.push(ids)
+			pending = pendingAddrs{}
 		case <-ids.ctx.Done():
 			return
 		}
@@ -200,6 +251,8 @@ func (ids *IDService) IdentifyConn(c network.Conn) {
 		// emit the appropriate event.
 		if p := c.RemotePeer(); err == nil {
 			ids.emitters.evtPeerIdentificationCompleted.Emit(event.EvtPeerIdentificationCompleted{Peer: p})
+			info, _ := ids.PeerInfo(p)
+			ids.emitters.evtPeerIdentified.Emit(EvtPeerIdentified{Peer: p, Info: info})
 		} else {
 			ids.emitters.evtPeerIdentificationFailed.Emit(event.EvtPeerIdentificationFailed{Peer: p, Reason: err})
 		}
@@ -222,43 +275,48 @@ func (ids *IDService) IdentifyConn(c network.Conn) {
 		return
 	}
 
-	ids.responseHandler(s)
+	err = ids.responseHandler(s)
 }
 
 func (ids *IDService) requestHandler(s network.Stream) {
 	defer helpers.FullClose(s)
 	c := s.Conn()
 
-	w := ggio.NewDelimitedWriter(s)
 	mes := pb.Identify{}
 	ids.populateMessage(&mes, s.Conn())
-	w.WriteMsg(&mes)
+	if err := writeMessage(s, &mes); err != nil {
+		log.Debugf("%s error sending message to %s: %s", ID, c.RemotePeer(), err)
+		return
+	}
 
 	log.Debugf("%s sent message to %s %s", ID, c.RemotePeer(), c.RemoteMultiaddr())
 }
 
-func (ids *IDService) responseHandler(s network.Stream) {
+func (ids *IDService) responseHandler(s network.Stream) error {
 	c := s.Conn()
 
-	r := ggio.NewDelimitedReader(s, 2048)
 	mes := pb.Identify{}
-	if err := r.ReadMsg(&mes); err != nil {
+	if err := ids.readMessage(s, &mes); err != nil {
 		log.Warning("error reading identify message: ", err)
 		s.Reset()
-		return
+		return err
 	}
 
 	defer func() { go helpers.FullClose(s) }()
 
 	log.Debugf("%s received message from %s %s", s.Protocol(), c.RemotePeer(), c.RemoteMultiaddr())
 	ids.consumeMessage(&mes, c)
+	return nil
 }
 
-func (ids *IDService) broadcast(proto protocol.ID, payloadWriter func(s network.Stream)) {
+// broadcast opens a stream with the first of the given protocols supported by
+// the peer to every connected peer and writes the payload. The returned
+// channel is closed once all writes are done.
+func (ids *IDService) broadcast(protos []protocol.ID, payloadWriter func(s network.Stream)) <-chan struct{} {
 	var wg sync.WaitGroup
 
 	ctx, cancel := context.WithTimeout(ids.ctx, 30*time.Second)
-	ctx = network.WithNoDial(ctx, string(proto))
+	ctx = network.WithNoDial(ctx, string(protos[0]))
 
 	pstore := ids.Host.Peerstore()
 	for _, p := range ids.Host.Network().Peers() {
@@ -285,13 +343,13 @@ func (ids *IDService) broadcast(proto protocol.ID, payloadWriter func(s network.
 			}
 
 			// avoid the unnecessary stream if the peer does not support the protocol.
-			if sup, err := pstore.SupportsProtocols(p, string(proto)); err != nil && len(sup) == 0 {
+			if sup, err := pstore.SupportsProtocols(p, protocol.ConvertToStrings(protos)...); err != nil && len(sup) == 0 {
 				// the peer does not support the required protocol.
 				return
 			}
 			// if the peerstore query errors, we go ahead anyway.
 
-			s, err := ids.Host.NewStream(ctx, p, proto)
+			s, err := ids.Host.NewStream(ctx, p, protos...)
 			if err != nil {
 				log.Debugf("error opening push stream to %s: %s", p, err.Error())
 				return
@@ -313,10 +371,13 @@ func (ids *IDService) broadcast(proto protocol.ID, payloadWriter func(s network.
 	}
 
 	// this supervisory goroutine is necessary to cancel the context
+	done := make(chan struct{})
 	go func() {
 		wg.Wait()
 		cancel()
+		close(done)
 	}()
+	return done
 }
 
 func (ids *IDService) populateMessage(mes *pb.Identify, c network.Conn) {
@@ -333,17 +394,18 @@ func (ids *IDService) populateMessage(mes *pb.Identify, c network.Conn) {
 
 	// set listen addrs, get our latest addrs from Host.
 	laddrs := ids.Host.Addrs()
-	// Note: LocalMultiaddr is sometimes 0.0.0.0
-	viaLoopback := manet.IsIPLoopback(c.LocalMultiaddr()) || manet.IsIPLoopback(c.RemoteMultiaddr())
-	mes.ListenAddrs = make([][]byte, 0, len(laddrs))
-	for _, addr := range laddrs {
-		if !viaLoopback && manet.IsIPLoopback(addr) {
-			continue
-		}
-		mes.ListenAddrs = append(mes.ListenAddrs, addr.Bytes())
-	}
+	loopback := viaLoopback(c)
+	saddrs := listenAddrsFor(laddrs, loopback)
+	mes.ListenAddrs = addrsBytes(saddrs)
 	log.Debugf("%s sent listen addrs to %s: %s", c.LocalPeer(), c.RemotePeer(), laddrs)
 
+	// certify the listen addrs with a signed peer record.
+	if rec, err := ids.signedPeerRecord(saddrs, loopback); err != nil {
+		log.Errorf("%s", err)
+	} else {
+		mes.SignedPeerRecord = rec
+	}
+
 	// set our public key
 	ownKey := ids.Host.Peerstore().PubKey(ids.Host.ID())
 
@@ -372,6 +434,25 @@ func (ids *IDService) populateMessage(mes *pb.Identify, c network.Conn) {
 	mes.AgentVersion = &av
 }
 
+// viaLoopback tells whether the connection is over the loopback interface.
+func viaLoopback(c network.Conn) bool {
+	// Note: LocalMultiaddr is sometimes 0.0.0.0
+	return manet.IsIPLoopback(c.LocalMultiaddr()) || manet.IsIPLoopback(c.RemoteMultiaddr())
+}
+
+// listenAddrsFor returns the listen addrs to send to a peer. Loopback addrs
+// are only sent over loopback connections.
+func listenAddrsFor(addrs []ma.Multiaddr, viaLoopback bool) []ma.Multiaddr {
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if !viaLoopback && manet.IsIPLoopback(addr) {
+			continue
+		}
+		out = append(out, addr)
+	}
+	return out
+}
+
 func (ids *IDService) consumeMessage(mes *pb.Identify, c network.Conn) {
 	p := c.RemotePeer()
 
@@ -405,16 +486,23 @@ func (ids *IDService) consumeMessage(mes *pb.Identify, c network.Conn) {
 	// Extend the TTLs on the known (probably) good addresses.
 	// Taking the lock ensures that we don't concurrently process a disconnect.
 	ids.addrMu.Lock()
-	switch ids.Host.Network().Connectedness(p) {
-	case network.Connected:
-		// invalidate previous addrs -- we use a transient ttl instead of 0 to ensure there
-		// is no period of having no good addrs whatsoever
-		ids.Host.Peerstore().UpdateAddrs(p, peerstore.ConnectedAddrTTL, transientTTL)
-		ids.Host.Peerstore().AddAddrs(p, lmaddrs, peerstore.ConnectedAddrTTL)
-	default:
-		ids.Host.Peerstore().UpdateAddrs(p, peerstore.ConnectedAddrTTL, transientTTL)
-		ids.Host.Peerstore().AddAddrs(p, lmaddrs, peerstore.RecentlyConnectedAddrTTL)
+	ttl := peerstore.RecentlyConnectedAddrTTL
+	if ids.Host.Network().Connectedness(p) == network.Connected {
+		ttl = peerstore.ConnectedAddrTTL
 	}
+
+	// addrs certified by a signed peer record are preferred over the
+	// unsigned ones.
+	if rec := mes.GetSignedPeerRecord(); rec != nil {
+		if caddrs, ok := ids.consumeSignedPeerRecord(c, rec, ttl); ok {
+			lmaddrs = caddrs
+		}
+	}
+
+	// invalidate previous addrs -- we use a transient ttl instead of 0 to ensure there
+	// is no period of having no good addrs whatsoever
+	ids.Host.Peerstore().UpdateAddrs(p, peerstore.ConnectedAddrTTL, transientTTL)
+	ids.Host.Peerstore().AddAddrs(p, lmaddrs, ttl)
 	ids.addrMu.Unlock()
 
 	log.Debugf("%s received listen addrs for %s: %s", c.LocalPeer(), c.RemotePeer(), lmaddrs)
@@ -426,6 +514,20 @@ func (ids *IDService) consumeMessage(mes *pb.Identify, c network.Conn) {
 	ids.Host.Peerstore().Put(p, "ProtocolVersion", pv)
 	ids.Host.Peerstore().Put(p, "AgentVersion", av)
 
+	var obsAddr ma.Multiaddr
+	if b := mes.GetObservedAddr(); b != nil {
+		obsAddr, _ = ma.NewMultiaddrBytes(b)
+	}
+	ids.updatePeerInfo(c, func(info *PeerInfo) {
+		*info = PeerInfo{
+			ProtocolVersion: pv,
+			AgentVersion:    av,
+			Protocols:       protocol.ConvertFromStrings(mes.Protocols),
+			ListenAddrs:     lmaddrs,
+			ObservedAddr:    obsAddr,
+		}
+	})
+
 	// get the key from the other side. we may not have it (no-auth transport)
 	ids.consumeReceivedPubKey(c, mes.PublicKey)
 }
@@ -623,6 +725,7 @@ func (nn *netNotifiee) Disconnected(n network.Network, v network.Conn) {
 		ps := ids.Host.Peerstore()
 		ps.UpdateAddrs(v.RemotePeer(), peerstore.ConnectedAddrTTL, peerstore.RecentlyConnectedAddrTTL)
 	}
+	ids.forgetPeerInfo(v.RemotePeer())
 }
 
 func (nn *netNotifiee) OpenedStream(n network.Network, v network.Stream) {}
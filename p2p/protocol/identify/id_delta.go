@@ -4,22 +4,30 @@ import (
 	"github.com/libp2p/go-libp2p-core/event"
 	"github.com/libp2p/go-libp2p-core/helpers"
 	"github.com/libp2p/go-libp2p-core/network"
-	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
 
-	ggio "github.com/gogo/protobuf/io"
 	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
-const IDDelta = "/p2p/id/delta/1.0.0"
+// IDDelta is the protocol.ID of the identify delta protocol, announcing the
+// changes of the protocols and listen addrs of a peer. Version 1.1.0 added the
+// addrs to the delta message.
+const IDDelta = "/p2p/id/delta/1.1.0"
+
+// IDDeltaLegacy is the protocol.ID of the previous version of the identify
+// delta protocol, which only announces protocol changes. Peers only speaking
+// it receive our address changes through identify push.
+const IDDeltaLegacy = "/p2p/id/delta/1.0.0"
 
 // deltaHandler handles incoming delta updates from peers.
 func (ids *IDService) deltaHandler(s network.Stream) {
 	c := s.Conn()
 
-	r := ggio.NewDelimitedReader(s, 2048)
 	mes := pb.Identify{}
-	if err := r.ReadMsg(&mes); err != nil {
+	if err := ids.readMessage(s, &mes); err != nil {
 		log.Warning("error reading identify message: ", err)
 		s.Reset()
 		return
@@ -35,13 +43,20 @@ func (ids *IDService) deltaHandler(s network.Stream) {
 	}
 
 	p := s.Conn().RemotePeer()
-	if err := ids.consumeDelta(p, delta); err != nil {
+	if err := ids.consumeDelta(c, delta); err != nil {
 		log.Warningf("delta update from peer %s failed: %s", p, err)
 	}
 }
 
+// PushProtocolsRemoved sends a delta message to all connected peers announcing
+// that the given protocols are no longer supported. The returned channel is
+// closed once all peers have been notified (or the sends timed out).
+func (ids *IDService) PushProtocolsRemoved(protos []protocol.ID) <-chan struct{} {
+	return ids.fireProtocolDelta(event.EvtLocalProtocolsUpdated{Removed: protos})
+}
+
 // fireProtocolDelta fires a delta message to all connected peers to signal a local protocol table update.
-func (ids *IDService) fireProtocolDelta(evt event.EvtLocalProtocolsUpdated) {
+func (ids *IDService) fireProtocolDelta(evt event.EvtLocalProtocolsUpdated) <-chan struct{} {
 	mes := pb.Identify{
 		Delta: &pb.Delta{
 			AddedProtocols: protocol.ConvertToStrings(evt.Added),
@@ -51,34 +66,169 @@ func (ids *IDService) fireProtocolDelta(evt event.EvtLocalProtocolsUpdated) {
 	deltaWriter := func(s network.Stream) {
 		defer helpers.FullClose(s)
 		c := s.Conn()
-		err := ggio.NewDelimitedWriter(s).WriteMsg(&mes)
+		err := writeMessage(s, &mes)
 		if err != nil {
 			log.Warningf("%s error while sending delta update to %s: %s", IDDelta, c.RemotePeer(), c.RemoteMultiaddr())
 			return
 		}
 		log.Debugf("%s sent delta update to %s: %s", IDDelta, c.RemotePeer(), c.RemoteMultiaddr())
 	}
-	ids.broadcast(IDDelta, deltaWriter)
+	return ids.broadcast([]protocol.ID{IDDelta, IDDeltaLegacy}, deltaWriter)
+}
+
+// PushAddrs sends a delta message to all connected peers announcing the
+// changes of our listen addrs, along with our current agent and protocol
+// versions. Peers not supporting this version of identify delta receive a full
+// identify push instead. The returned channel is closed once all peers have been notified
+// (or the sends timed out).
+func (ids *IDService) PushAddrs(added, removed []ma.Multiaddr) <-chan struct{} {
+	writer := func(s network.Stream) {
+		if s.Protocol() != IDDelta {
+			ids.requestHandler(s)
+			return
+		}
+
+		defer helpers.FullClose(s)
+		c := s.Conn()
+		mes := pb.Identify{Delta: ids.addrsDelta(c, added, removed)}
+		err := writeMessage(s, &mes)
+		if err != nil {
+			log.Warningf("%s error while sending delta update to %s: %s", IDDelta, c.RemotePeer(), c.RemoteMultiaddr())
+			return
+		}
+		log.Debugf("%s sent addrs delta update to %s: %s", IDDelta, c.RemotePeer(), c.RemoteMultiaddr())
+	}
+	return ids.broadcast([]protocol.ID{IDDelta, IDPush}, writer)
+}
+
+// addrsDelta builds the delta announcing the changes of our listen addrs to
+// the peer on the connection.
+func (ids *IDService) addrsDelta(c network.Conn, added, removed []ma.Multiaddr) *pb.Delta {
+	pv := LibP2PVersion
+	av := ids.UserAgent
+	loopback := viaLoopback(c)
+	delta := &pb.Delta{
+		AddedAddrs:      addrsBytes(listenAddrsFor(added, loopback)),
+		RmAddrs:         addrsBytes(listenAddrsFor(removed, loopback)),
+		AgentVersion:    &av,
+		ProtocolVersion: &pv,
+	}
+
+	// certify our current listen addrs.
+	laddrs := listenAddrsFor(ids.Host.Addrs(), loopback)
+	if rec, err := ids.signedPeerRecord(laddrs, loopback); err != nil {
+		log.Errorf("%s", err)
+	} else {
+		delta.SignedPeerRecord = rec
+	}
+	return delta
 }
 
 // consumeDelta processes an incoming delta from a peer, updating the peerstore
 // and emitting the appropriate events.
-func (ids *IDService) consumeDelta(id peer.ID, delta *pb.Delta) error {
-	err := ids.Host.Peerstore().AddProtocols(id, delta.GetAddedProtocols()...)
-	if err != nil {
-		return err
-	}
+func (ids *IDService) consumeDelta(c network.Conn, delta *pb.Delta) error {
+	id := c.RemotePeer()
+	if len(delta.GetAddedProtocols()) > 0 || len(delta.GetRmProtocols()) > 0 {
+		err := ids.Host.Peerstore().AddProtocols(id, delta.GetAddedProtocols()...)
+		if err != nil {
+			return err
+		}
+
+		err = ids.Host.Peerstore().RemoveProtocols(id, delta.GetRmProtocols()...)
+		if err != nil {
+			return err
+		}
 
-	err = ids.Host.Peerstore().RemoveProtocols(id, delta.GetRmProtocols()...)
-	if err != nil {
-		return err
+		evt := event.EvtPeerProtocolsUpdated{
+			Peer:    id,
+			Added:   protocol.ConvertFromStrings(delta.GetAddedProtocols()),
+			Removed: protocol.ConvertFromStrings(delta.GetRmProtocols()),
+		}
+		ids.emitters.evtPeerProtocolsUpdated.Emit(evt)
 	}
 
-	evt := event.EvtPeerProtocolsUpdated{
-		Peer:    id,
-		Added:   protocol.ConvertFromStrings(delta.GetAddedProtocols()),
-		Removed: protocol.ConvertFromStrings(delta.GetRmProtocols()),
+	ids.consumeAddrsDelta(c, delta)
+
+	if pv := delta.ProtocolVersion; pv != nil {
+		ids.Host.Peerstore().Put(id, "ProtocolVersion", *pv)
 	}
-	ids.emitters.evtPeerProtocolsUpdated.Emit(evt)
+	if av := delta.AgentVersion; av != nil {
+		ids.Host.Peerstore().Put(id, "AgentVersion", *av)
+	}
+
+	ids.updatePeerInfo(c, func(info *PeerInfo) {
+		info.Protocols = addProtocols(info.Protocols, delta.GetAddedProtocols())
+		info.Protocols = removeProtocols(info.Protocols, delta.GetRmProtocols())
+		if pv := delta.ProtocolVersion; pv != nil {
+			info.ProtocolVersion = *pv
+		}
+		if av := delta.AgentVersion; av != nil {
+			info.AgentVersion = *av
+		}
+	})
 	return nil
 }
+
+// consumeAddrsDelta updates the addrs of the peer from the delta.
+func (ids *IDService) consumeAddrsDelta(c network.Conn, delta *pb.Delta) {
+	added := delta.GetAddedAddrs()
+	removed := delta.GetRmAddrs()
+	rec := delta.GetSignedPeerRecord()
+	if len(added) == 0 && len(removed) == 0 && rec == nil {
+		return
+	}
+
+	p := c.RemotePeer()
+	pstore := ids.Host.Peerstore()
+
+	// Taking the lock ensures that we don't concurrently process a disconnect.
+	ids.addrMu.Lock()
+	defer ids.addrMu.Unlock()
+	ttl := peerstore.RecentlyConnectedAddrTTL
+	if ids.Host.Network().Connectedness(p) == network.Connected {
+		ttl = peerstore.ConnectedAddrTTL
+	}
+
+	// a signed peer record carries the full set of addrs, which is preferred
+	// over the unsigned changes.
+	if rec != nil {
+		if caddrs, ok := ids.consumeSignedPeerRecord(c, rec, ttl); ok {
+			pstore.UpdateAddrs(p, peerstore.ConnectedAddrTTL, transientTTL)
+			pstore.AddAddrs(p, caddrs, ttl)
+			ids.updatePeerInfo(c, func(info *PeerInfo) {
+				info.ListenAddrs = caddrs
+			})
+			return
+		}
+	}
+
+	addedAddrs := addrsFromBytes(added)
+	removedAddrs := addrsFromBytes(removed)
+	pstore.AddAddrs(p, addedAddrs, ttl)
+	// a ttl of 0 removes the addrs.
+	pstore.SetAddrs(p, removedAddrs, 0)
+	ids.updatePeerInfo(c, func(info *PeerInfo) {
+		info.ListenAddrs = removeAddrs(addAddrs(info.ListenAddrs, addedAddrs), removedAddrs)
+	})
+}
+
+func addrsBytes(addrs []ma.Multiaddr) [][]byte {
+	out := make([][]byte, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.Bytes())
+	}
+	return out
+}
+
+func addrsFromBytes(bs [][]byte) []ma.Multiaddr {
+	out := make([]ma.Multiaddr, 0, len(bs))
+	for _, b := range bs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			log.Debugf("%s failed to parse multiaddr: %s", IDDelta, err)
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
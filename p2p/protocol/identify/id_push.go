@@ -1,6 +1,12 @@
 package identify
 
-import "github.com/libp2p/go-libp2p-core/network"
+import (
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
 
 // IDPush is the protocol.ID of the Identify push protocol. It sends full identify messages containing
 // the current state of the peer.
@@ -11,10 +17,61 @@ const IDPush = "/ipfs/id/push/1.0.0"
 
 // Push pushes a full identify message to all peers containing the current state.
 func (ids *IDService) Push() {
-	ids.broadcast(IDPush, ids.requestHandler)
+	ids.broadcast([]protocol.ID{IDPush}, ids.requestHandler)
+}
+
+// pendingAddrs collects the changes of our addresses that weren't pushed to
+// peers yet.
+type pendingAddrs struct {
+	added, removed map[string]ma.Multiaddr
+	// set when we were told of a change without the diff; all peers get a
+	// full push then.
+	full bool
+}
+
+func (pa *pendingAddrs) update(evt event.EvtLocalAddressesUpdated) {
+	if !evt.Diffs {
+		pa.full = true
+		return
+	}
+	if pa.added == nil {
+		pa.added = make(map[string]ma.Multiaddr)
+		pa.removed = make(map[string]ma.Multiaddr)
+	}
+	for _, ua := range evt.Current {
+		if ua.Action == event.Added {
+			k := string(ua.Address.Bytes())
+			pa.added[k] = ua.Address
+			delete(pa.removed, k)
+		}
+	}
+	for _, ua := range evt.Removed {
+		k := string(ua.Address.Bytes())
+		pa.removed[k] = ua.Address
+		delete(pa.added, k)
+	}
+}
+
+func (pa *pendingAddrs) push(ids *IDService) {
+	if pa.full {
+		ids.Push()
+		return
+	}
+	if len(pa.added) == 0 && len(pa.removed) == 0 {
+		return
+	}
+	added := make([]ma.Multiaddr, 0, len(pa.added))
+	for _, a := range pa.added {
+		added = append(added, a)
+	}
+	removed := make([]ma.Multiaddr, 0, len(pa.removed))
+	for _, a := range pa.removed {
+		removed = append(removed, a)
+	}
+	ids.PushAddrs(added, removed)
 }
 
 // pushHandler handles incoming identify push streams. The behaviour is identical to the ordinary identify protocol.
 func (ids *IDService) pushHandler(s network.Stream) {
-	ids.responseHandler(s)
+	_ = ids.responseHandler(s)
 }
@@ -1,7 +1,9 @@
 package identify_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
@@ -17,12 +19,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p-core/record"
 	coretest "github.com/libp2p/go-libp2p-core/test"
 
 	blhost "github.com/libp2p/go-libp2p-blankhost"
 	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
 
+	ggio "github.com/gogo/protobuf/io"
 	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	ma "github.com/multiformats/go-multiaddr"
@@ -494,3 +499,518 @@ func TestUserAgent(t *testing.T) {
 		t.Errorf("expected agent version %q, got %q", "bar", av)
 	}
 }
+
+func TestSignedPeerRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1 := identify.NewIDService(ctx, h1)
+	_ = identify.NewIDService(ctx, h2)
+
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+
+	env := ids1.SignedPeerRecord(h2.ID())
+	if env == nil {
+		t.Fatal("expected a signed peer record")
+	}
+	if !env.PublicKey.Equals(h2.Peerstore().PubKey(h2.ID())) {
+		t.Error("expected the record to be signed by h2")
+	}
+	r, err := env.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := r.(*peer.PeerRecord)
+	if rec.PeerID != h2.ID() {
+		t.Errorf("expected a record of %s, got %s", h2.ID(), rec.PeerID)
+	}
+	if !reflect.DeepEqual(rec.Addrs, h2.Addrs()) {
+		t.Errorf("expected the record to contain %s, got %s", h2.Addrs(), rec.Addrs)
+	}
+}
+
+func TestSignedPeerRecordManyAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the listen addrs and the record certifying them don't fit into the
+	// 2KiB older versions accept.
+	var extra []ma.Multiaddr
+	for i := 0; i < 100; i++ {
+		extra = append(extra, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", 1000+i)))
+	}
+	h1, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2, err := libp2p.New(
+		ctx,
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return append(addrs, extra...)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	// h1 only learns the extra addrs through identify.
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Network().ListenAddresses()}); err != nil {
+		t.Fatal(err)
+	}
+	addrs := h1.Peerstore().Addrs(h2.ID())
+	for _, a := range extra {
+		if !containsAddr(addrs, a) {
+			t.Fatalf("expected h1 to learn %s", a)
+		}
+	}
+}
+
+func TestPeerRecordVerification(t *testing.T) {
+	unsigned := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	certified := ma.StringCast("/ip4/1.2.3.4/tcp/2345")
+	otherKey, _, err := coretest.RandTestKeyPair(ic.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		// signs the record of h2, nil if there's none.
+		key       func(h2 host.Host) ic.PrivKey
+		certified bool
+	}{
+		{"unsigned", nil, false},
+		{"signed", func(h2 host.Host) ic.PrivKey { return h2.Peerstore().PrivKey(h2.ID()) }, true},
+		{"forged", func(host.Host) ic.PrivKey { return otherKey }, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+			h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+			defer h2.Close()
+			defer h1.Close()
+			ids1 := identify.NewIDService(ctx, h1)
+
+			// h2 sends its unsigned addr, and the other one in its record.
+			mes := &pb.Identify{ListenAddrs: [][]byte{unsigned.Bytes()}}
+			if tc.key != nil {
+				rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: h2.ID(), Addrs: []ma.Multiaddr{certified}})
+				env, err := record.Seal(rec, tc.key(h2))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mes.SignedPeerRecord, err = env.Marshal(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h2.SetStreamHandler(identify.ID, func(s network.Stream) {
+				defer helpers.FullClose(s)
+				ggio.NewDelimitedWriter(s).WriteMsg(mes)
+			})
+
+			if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+				t.Fatal(err)
+			}
+			ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+
+			has := map[string]bool{}
+			for _, a := range h1.Peerstore().Addrs(h2.ID()) {
+				has[a.String()] = true
+			}
+			if has[certified.String()] != tc.certified || has[unsigned.String()] == tc.certified {
+				t.Errorf("expected only the certified addr: %t, got %s", tc.certified, h1.Peerstore().Addrs(h2.ID()))
+			}
+			if env := ids1.SignedPeerRecord(h2.ID()); (env != nil) != tc.certified {
+				t.Errorf("expected a stored record: %t, got %v", tc.certified, env)
+			}
+		})
+	}
+}
+
+func TestIdentifyDeltaOnAddrChange(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// the protocol h1 does not handle.
+		unsupported protocol.ID
+	}{
+		{"delta", identify.IDPush},
+		// h1 only speaks delta 1.0.0, which doesn't carry addrs.
+		{"push fallback", identify.IDDelta},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+			h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+			defer h2.Close()
+			defer h1.Close()
+
+			ids1 := identify.NewIDService(ctx, h1)
+			ids2 := identify.NewIDService(ctx, h2)
+			h1.RemoveStreamHandler(tc.unsupported)
+
+			if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+				t.Fatal(err)
+			}
+			ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+			ids2.IdentifyConn(h2.Network().ConnsToPeer(h1.ID())[0])
+
+			before := h2.Addrs()
+			if err := h2.Network().Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")); err != nil {
+				t.Fatal(err)
+			}
+			var added []ma.Multiaddr
+			for _, a := range h2.Addrs() {
+				if !containsAddr(before, a) {
+					added = append(added, a)
+				}
+			}
+			if len(added) != 1 {
+				t.Fatalf("expected a new listen addr, got %s", h2.Addrs())
+			}
+
+			ids2.UserAgent = "updated"
+			select {
+			case <-ids2.PushAddrs(added, nil):
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out while pushing the addrs")
+			}
+
+			// the handler runs after the stream was written.
+			deadline := time.Now().Add(5 * time.Second)
+			for !containsAddr(h1.Peerstore().Addrs(h2.ID()), added[0]) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %s to be learned, got %s", added[0], h1.Peerstore().Addrs(h2.ID()))
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if av, _ := h1.Peerstore().Get(h2.ID(), "AgentVersion"); av != "updated" {
+				t.Errorf("expected the updated agent version, got %v", av)
+			}
+		})
+	}
+}
+
+func containsAddr(addrs []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIdentifyDeltaLegacy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	defer h1.Close()
+
+	h2.SetStreamHandler(protocol.TestingID, func(_ network.Stream) {})
+	ids2 := identify.NewIDService(ctx, h2)
+
+	// h1 only speaks delta 1.0.0, which ignores the addrs of deltas.
+	_ = identify.NewIDService(ctx, h1)
+	h1.RemoveStreamHandler(identify.IDDelta)
+	deltas := make(chan *pb.Delta, 4)
+	h1.SetStreamHandler(identify.IDDeltaLegacy, func(s network.Stream) {
+		defer helpers.FullClose(s)
+		var mes pb.Identify
+		if err := ggio.NewDelimitedReader(s, 2048).ReadMsg(&mes); err != nil {
+			t.Error(err)
+			return
+		}
+		deltas <- mes.GetDelta()
+	})
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	ids2.IdentifyConn(h2.Network().ConnsToPeer(h1.ID())[0])
+
+	// protocol changes are sent over delta 1.0.0.
+	h2.RemoveStreamHandler(protocol.TestingID)
+	select {
+	case delta := <-deltas:
+		if rm := delta.GetRmProtocols(); len(rm) != 1 || rm[0] != string(protocol.TestingID) {
+			t.Errorf("expected %s to be removed, got %v", protocol.TestingID, rm)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a delta")
+	}
+
+	// addr changes are pushed instead.
+	added := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	select {
+	case <-ids2.PushAddrs([]ma.Multiaddr{added}, nil):
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out while pushing the addrs")
+	}
+	select {
+	case delta := <-deltas:
+		t.Fatalf("expected the addrs not to be sent over delta 1.0.0, got %v", delta)
+	default:
+	}
+}
+
+func TestIdentifyPushDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	defer h1.Close()
+
+	_ = identify.NewIDService(ctx, h2, identify.PushDebounce(100*time.Millisecond))
+	deltas := make(chan *pb.Delta, 4)
+	h1.SetStreamHandler(identify.IDDelta, func(s network.Stream) {
+		defer helpers.FullClose(s)
+		var mes pb.Identify
+		if err := ggio.NewDelimitedReader(s, 2048).ReadMsg(&mes); err != nil {
+			t.Error(err)
+			return
+		}
+		deltas <- mes.GetDelta()
+	})
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	emitter, err := h2.EventBus().Emitter(&event.EvtLocalAddressesUpdated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	a2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	a3 := ma.StringCast("/ip4/1.2.3.4/tcp/3")
+	for _, evt := range []event.EvtLocalAddressesUpdated{
+		{Diffs: true, Current: []event.UpdatedAddress{{Address: a1, Action: event.Added}}},
+		{Diffs: true, Current: []event.UpdatedAddress{{Address: a2, Action: event.Added}}, Removed: []event.UpdatedAddress{{Address: a3, Action: event.Removed}}},
+		{Diffs: true, Removed: []event.UpdatedAddress{{Address: a1, Action: event.Removed}}},
+	} {
+		if err := emitter.Emit(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the changes are pushed at once.
+	select {
+	case delta := <-deltas:
+		if added := delta.GetAddedAddrs(); len(added) != 1 || !bytes.Equal(added[0], a2.Bytes()) {
+			t.Errorf("expected %s to be added, got %v", a2, added)
+		}
+		if rm := delta.GetRmAddrs(); len(rm) != 2 {
+			t.Errorf("expected %s and %s to be removed, got %v", a1, a3, rm)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a delta")
+	}
+	select {
+	case delta := <-deltas:
+		t.Fatalf("unexpected delta: %v", delta)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestPeerInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1 := identify.NewIDService(ctx, h1)
+	_ = identify.NewIDService(ctx, h2, identify.UserAgent("h2"))
+
+	sub, err := h1.EventBus().Subscribe(new(identify.EvtPeerIdentified), eventbus.BufSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if _, ok := ids1.PeerInfo(h2.ID()); ok {
+		t.Fatal("expected no info before identifying h2")
+	}
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	c := h1.Network().ConnsToPeer(h2.ID())[0]
+	ids1.IdentifyConn(c)
+
+	var evt identify.EvtPeerIdentified
+	select {
+	case e := <-sub.Out():
+		evt = e.(identify.EvtPeerIdentified)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an EvtPeerIdentified event")
+	}
+	info, ok := ids1.PeerInfo(h2.ID())
+	if !ok {
+		t.Fatal("expected the info of h2")
+	}
+	if evt.Peer != h2.ID() || !reflect.DeepEqual(evt.Info, info) {
+		t.Errorf("expected the event to carry the info of h2, got %+v", evt)
+	}
+
+	if info.ProtocolVersion != identify.LibP2PVersion || info.AgentVersion != "h2" {
+		t.Errorf("unexpected versions %q and %q", info.ProtocolVersion, info.AgentVersion)
+	}
+	if !reflect.DeepEqual(info.ListenAddrs, h2.Addrs()) {
+		t.Errorf("expected the listen addrs %s, got %s", h2.Addrs(), info.ListenAddrs)
+	}
+	if info.ObservedAddr == nil || !info.ObservedAddr.Equal(c.LocalMultiaddr()) {
+		t.Errorf("expected h2 to observe us at %s, got %s", c.LocalMultiaddr(), info.ObservedAddr)
+	}
+	if info.Conn != c || info.Time.IsZero() {
+		t.Errorf("expected the identify time and connection, got %s and %v", info.Time, info.Conn)
+	}
+	if !containsProtocol(info.Protocols, identify.ID) || len(info.Protocols) != len(h2.Mux().Protocols()) {
+		t.Errorf("expected the protocols %s, got %s", h2.Mux().Protocols(), info.Protocols)
+	}
+
+	// deltas update the info.
+	h2.SetStreamHandler("/foo", func(s network.Stream) { s.Reset() })
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, _ := ids1.PeerInfo(h2.ID())
+		if containsProtocol(info.Protocols, "/foo") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the new protocol, got %s", info.Protocols)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// and it's forgotten once h2 disconnects.
+	h1.Network().ClosePeer(h2.ID())
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := ids1.PeerInfo(h2.ID()); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the info to be forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func containsProtocol(protos []protocol.ID, p protocol.ID) bool {
+	for _, q := range protos {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLargeIdentifyMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h3 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h3.Close()
+	defer h2.Close()
+	defer h1.Close()
+
+	// the protocols of h2 don't fit into a single frame.
+	for i := 0; i < 100; i++ {
+		h2.SetStreamHandler(protocol.ID(fmt.Sprintf("/test/some/rather/long/protocol/%d", i)), func(s network.Stream) { s.Reset() })
+	}
+	ids1 := identify.NewIDService(ctx, h1)
+	_ = identify.NewIDService(ctx, h2)
+	ids3 := identify.NewIDService(ctx, h3, identify.MaxMessageSize(1024))
+
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+	protos, err := h1.Peerstore().GetProtocols(h2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(protos) != len(h2.Mux().Protocols()) {
+		t.Errorf("expected %d protocols, got %d", len(h2.Mux().Protocols()), len(protos))
+	}
+
+	sub, err := h3.EventBus().Subscribe(new(event.EvtPeerIdentificationFailed), eventbus.BufSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := h3.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	ids3.IdentifyConn(h3.Network().ConnsToPeer(h2.ID())[0])
+	select {
+	case <-sub.Out():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected identifying h2 to fail with a smaller message size")
+	}
+}
+
+func TestIdentifyOpenStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1 := identify.NewIDService(ctx, h1)
+
+	// h2 sends a single message and leaves the stream open, like older
+	// versions do.
+	done := make(chan struct{})
+	defer close(done)
+	av := "h2"
+	h2.SetStreamHandler(identify.ID, func(s network.Stream) {
+		if err := ggio.NewDelimitedWriter(s).WriteMsg(&pb.Identify{AgentVersion: &av}); err != nil {
+			t.Error(err)
+		}
+		<-done
+		s.Close()
+	})
+
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	identified := make(chan struct{})
+	go func() {
+		ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+		close(identified)
+	}()
+	select {
+	case <-identified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected identify to complete while the stream is open")
+	}
+	if v, _ := h1.Peerstore().Get(h2.ID(), "AgentVersion"); v != av {
+		t.Errorf("expected agent version %q, got %v", av, v)
+	}
+}
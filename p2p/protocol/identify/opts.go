@@ -1,7 +1,11 @@
 package identify
 
+import "time"
+
 type config struct {
-	userAgent string
+	userAgent      string
+	pushDebounce   time.Duration
+	maxMessageSize int
 }
 
 // Option is an option function for identify.
@@ -13,3 +17,22 @@ func UserAgent(ua string) Option {
 		cfg.userAgent = ua
 	}
 }
+
+// PushDebounce sets how long changes of our addresses are collected before
+// they're pushed to peers. Defaults to 500ms.
+func PushDebounce(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.pushDebounce = d
+	}
+}
+
+// MaxMessageSize sets the maximum size of the identify messages we accept
+// from peers, in bytes. Larger messages are rejected. Messages are split into
+// frames of 2KiB, the maximum size of older versions, so peers announcing
+// many protocols or addresses can be identified; the limit applies to all
+// the frames of a message. Defaults to 8KiB.
+func MaxMessageSize(size int) Option {
+	return func(cfg *config) {
+		cfg.maxMessageSize = size
+	}
+}
@@ -26,7 +26,17 @@ type Delta struct {
 	// new protocols now serviced by the peer.
 	AddedProtocols []string `protobuf:"bytes,1,rep,name=added_protocols,json=addedProtocols" json:"added_protocols,omitempty"`
 	// protocols dropped by the peer.
-	RmProtocols          []string `protobuf:"bytes,2,rep,name=rm_protocols,json=rmProtocols" json:"rm_protocols,omitempty"`
+	RmProtocols []string `protobuf:"bytes,2,rep,name=rm_protocols,json=rmProtocols" json:"rm_protocols,omitempty"`
+	// new listen addrs of the peer.
+	AddedAddrs [][]byte `protobuf:"bytes,3,rep,name=added_addrs,json=addedAddrs" json:"added_addrs,omitempty"`
+	// listen addrs dropped by the peer.
+	RmAddrs [][]byte `protobuf:"bytes,4,rep,name=rm_addrs,json=rmAddrs" json:"rm_addrs,omitempty"`
+	// the current agent and protocol versions of the peer.
+	AgentVersion    *string `protobuf:"bytes,5,opt,name=agent_version,json=agentVersion" json:"agent_version,omitempty"`
+	ProtocolVersion *string `protobuf:"bytes,6,opt,name=protocol_version,json=protocolVersion" json:"protocol_version,omitempty"`
+	// a signed peer record certifying the current listen addrs of the peer.
+	// It supersedes added_addrs and rm_addrs.
+	SignedPeerRecord     []byte   `protobuf:"bytes,7,opt,name=signed_peer_record,json=signedPeerRecord" json:"signed_peer_record,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -79,6 +89,41 @@ func (m *Delta) GetRmProtocols() []string {
 	return nil
 }
 
+func (m *Delta) GetAddedAddrs() [][]byte {
+	if m != nil {
+		return m.AddedAddrs
+	}
+	return nil
+}
+
+func (m *Delta) GetRmAddrs() [][]byte {
+	if m != nil {
+		return m.RmAddrs
+	}
+	return nil
+}
+
+func (m *Delta) GetAgentVersion() string {
+	if m != nil && m.AgentVersion != nil {
+		return *m.AgentVersion
+	}
+	return ""
+}
+
+func (m *Delta) GetProtocolVersion() string {
+	if m != nil && m.ProtocolVersion != nil {
+		return *m.ProtocolVersion
+	}
+	return ""
+}
+
+func (m *Delta) GetSignedPeerRecord() []byte {
+	if m != nil {
+		return m.SignedPeerRecord
+	}
+	return nil
+}
+
 type Identify struct {
 	// protocolVersion determines compatibility between peers
 	ProtocolVersion *string `protobuf:"bytes,5,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
@@ -98,7 +143,13 @@ type Identify struct {
 	// protocols are the services this node is running
 	Protocols []string `protobuf:"bytes,3,rep,name=protocols" json:"protocols,omitempty"`
 	// a delta update is incompatible with everything else. If this field is included, none of the others can appear.
-	Delta                *Delta   `protobuf:"bytes,7,opt,name=delta" json:"delta,omitempty"`
+	Delta *Delta `protobuf:"bytes,7,opt,name=delta" json:"delta,omitempty"`
+	// signedPeerRecord contains a serialized record.Envelope wrapping a peer.PeerRecord,
+	// signed by the sender. It certifies the listen addrs of the sender.
+	SignedPeerRecord []byte `protobuf:"bytes,8,opt,name=signedPeerRecord" json:"signedPeerRecord,omitempty"`
+	// more is set on every frame of a message split into several frames but the
+	// last one, so that the receiver knows when the message is complete.
+	More                 *bool    `protobuf:"varint,9,opt,name=more" json:"more,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -186,6 +237,20 @@ func (m *Identify) GetDelta() *Delta {
 	return nil
 }
 
+func (m *Identify) GetSignedPeerRecord() []byte {
+	if m != nil {
+		return m.SignedPeerRecord
+	}
+	return nil
+}
+
+func (m *Identify) GetMore() bool {
+	if m != nil && m.More != nil {
+		return *m.More
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*Delta)(nil), "identify.pb.Delta")
 	proto.RegisterType((*Identify)(nil), "identify.pb.Identify")
@@ -194,23 +259,29 @@ func init() {
 func init() { proto.RegisterFile("identify.proto", fileDescriptor_83f1e7e6b485409f) }
 
 var fileDescriptor_83f1e7e6b485409f = []byte{
-	// 251 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x90, 0xb1, 0x4e, 0xc3, 0x30,
-	0x14, 0x45, 0xe5, 0x96, 0x02, 0x79, 0xb1, 0x5a, 0xe9, 0x4d, 0x1e, 0x50, 0x64, 0xb2, 0xe0, 0x29,
-	0x03, 0x7f, 0x00, 0x62, 0x41, 0x2c, 0xc8, 0x48, 0xac, 0x28, 0xa9, 0x1f, 0xc8, 0x52, 0x1a, 0x57,
-	0x8e, 0x41, 0xea, 0xce, 0xc7, 0x31, 0xf2, 0x09, 0x28, 0x5f, 0x82, 0xe2, 0x92, 0x26, 0x65, 0xf4,
-	0xd1, 0x91, 0xef, 0xbb, 0x17, 0x96, 0xd6, 0x50, 0x13, 0xec, 0xeb, 0xae, 0xd8, 0x7a, 0x17, 0x1c,
-	0xa6, 0xe3, 0xbb, 0xca, 0x9f, 0x60, 0x71, 0x47, 0x75, 0x28, 0xf1, 0x0a, 0x56, 0xa5, 0x31, 0x64,
-	0x5e, 0xa2, 0xb4, 0x76, 0x75, 0x2b, 0x98, 0x9c, 0xab, 0x44, 0x2f, 0x23, 0x7e, 0x1c, 0x28, 0x5e,
-	0x02, 0xf7, 0x9b, 0x89, 0x35, 0x8b, 0x56, 0xea, 0x37, 0x07, 0x25, 0xff, 0x9c, 0xc1, 0xf9, 0xfd,
-	0x5f, 0x08, 0x2a, 0x58, 0x0d, 0xf2, 0x33, 0xf9, 0xd6, 0xba, 0x46, 0x2c, 0x24, 0x53, 0x89, 0xfe,
-	0x8f, 0x31, 0x07, 0x5e, 0xbe, 0x51, 0x13, 0x06, 0xed, 0x34, 0x6a, 0x47, 0x0c, 0x2f, 0x20, 0xd9,
-	0xbe, 0x57, 0xb5, 0x5d, 0x3f, 0xd0, 0x4e, 0x30, 0xc9, 0x14, 0xd7, 0x23, 0x40, 0x09, 0x69, 0x6d,
-	0xdb, 0x40, 0xcd, 0x8d, 0x31, 0x7e, 0x7f, 0x1a, 0xd7, 0x53, 0xd4, 0x67, 0xb8, 0xaa, 0x25, 0xff,
-	0x41, 0xa6, 0x07, 0xe2, 0x24, 0x7e, 0x71, 0xc4, 0x62, 0xc6, 0xa1, 0xde, 0x3c, 0xd6, 0x1b, 0x01,
-	0x2a, 0x58, 0x98, 0x7e, 0x31, 0x71, 0x26, 0x99, 0x4a, 0xaf, 0xb1, 0x98, 0xcc, 0x59, 0xc4, 0x2d,
-	0xf5, 0x5e, 0xb8, 0xe5, 0x5f, 0x5d, 0xc6, 0xbe, 0xbb, 0x8c, 0xfd, 0x74, 0x19, 0xfb, 0x0d, 0x00,
-	0x00, 0xff, 0xff, 0xa1, 0x77, 0x03, 0x42, 0x87, 0x01, 0x00, 0x00,
+	// 351 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xc1, 0x4e, 0xf2, 0x40,
+	0x10, 0xc7, 0xb3, 0x14, 0x3e, 0xda, 0x69, 0x3f, 0x20, 0x7b, 0xda, 0x2f, 0xf9, 0x82, 0x15, 0x0f,
+	0xae, 0xc6, 0x70, 0xf0, 0x0d, 0x34, 0x5e, 0x8c, 0x17, 0xb2, 0x07, 0xaf, 0xa4, 0xb0, 0x23, 0x69,
+	0xd2, 0x76, 0xc9, 0xb6, 0x92, 0xf0, 0x10, 0xbe, 0x95, 0x07, 0x8f, 0x3e, 0x82, 0xe1, 0x49, 0x4c,
+	0xa7, 0x2d, 0x05, 0xf1, 0xd6, 0xfe, 0xe6, 0x9f, 0x99, 0xff, 0xfe, 0x67, 0x60, 0x10, 0x6b, 0xcc,
+	0x8a, 0xf8, 0x65, 0x3b, 0x5d, 0x5b, 0x53, 0x18, 0xee, 0xb7, 0xff, 0x8b, 0xc9, 0x5b, 0x07, 0x7a,
+	0x0f, 0x98, 0x14, 0x11, 0xbf, 0x84, 0x61, 0xa4, 0x35, 0xea, 0x39, 0xa9, 0x96, 0x26, 0xc9, 0x05,
+	0x0b, 0x1d, 0xe9, 0xa9, 0x01, 0xe1, 0x59, 0x43, 0xf9, 0x39, 0x04, 0x36, 0x3d, 0x50, 0x75, 0x48,
+	0xe5, 0xdb, 0xb4, 0x95, 0x9c, 0x81, 0x5f, 0xf5, 0x8a, 0xb4, 0xb6, 0xb9, 0x70, 0x42, 0x47, 0x06,
+	0x0a, 0x08, 0xdd, 0x95, 0x84, 0xff, 0x03, 0xd7, 0xa6, 0x75, 0xb5, 0x4b, 0xd5, 0xbe, 0x4d, 0xab,
+	0xd2, 0x05, 0xfc, 0x8d, 0x56, 0x98, 0x15, 0xf3, 0x0d, 0xda, 0x3c, 0x36, 0x99, 0xe8, 0x85, 0x4c,
+	0x7a, 0x2a, 0x20, 0xf8, 0x5c, 0x31, 0x7e, 0x05, 0xa3, 0xc6, 0xc0, 0x5e, 0xf7, 0x87, 0x74, 0xc3,
+	0x86, 0x37, 0xd2, 0x1b, 0xe0, 0x79, 0xbc, 0xca, 0xca, 0x87, 0x21, 0xda, 0xb9, 0xc5, 0xa5, 0xb1,
+	0x5a, 0xf4, 0x43, 0x26, 0x03, 0x35, 0xaa, 0x2a, 0x33, 0x44, 0xab, 0x88, 0x4f, 0xde, 0x3b, 0xe0,
+	0x3e, 0xd6, 0xf9, 0x70, 0x09, 0x3f, 0xbb, 0xd5, 0x66, 0x4e, 0x86, 0x4c, 0xe0, 0xc8, 0x5f, 0xed,
+	0xe5, 0xd8, 0xf3, 0x7f, 0xf0, 0xd6, 0xaf, 0x8b, 0x24, 0x5e, 0x3e, 0xe1, 0x56, 0x30, 0x9a, 0xdf,
+	0x02, 0x1e, 0x82, 0x9f, 0xc4, 0x79, 0x81, 0x19, 0xa5, 0x40, 0xa1, 0x06, 0xea, 0x10, 0x95, 0x33,
+	0xcc, 0x22, 0x47, 0xbb, 0xa9, 0x42, 0x14, 0x5d, 0x6a, 0x71, 0xc4, 0x68, 0xc6, 0x7e, 0x31, 0x0e,
+	0x2d, 0xa6, 0x05, 0x5c, 0x42, 0x4f, 0x97, 0xbb, 0xa6, 0xd7, 0xfb, 0xb7, 0x7c, 0x7a, 0x70, 0x09,
+	0x53, 0xba, 0x02, 0x55, 0x09, 0xf8, 0x35, 0x9c, 0x44, 0x23, 0xdc, 0xdf, 0x23, 0xe3, 0x1c, 0xba,
+	0xa9, 0xb1, 0x28, 0xbc, 0x90, 0x49, 0x57, 0xd1, 0xf7, 0x7d, 0xf0, 0xb1, 0x1b, 0xb3, 0xcf, 0xdd,
+	0x98, 0x7d, 0xed, 0xc6, 0xec, 0x7b, 0x00, 0x7f, 0x44, 0x0b, 0xef, 0x82, 0x02, 0x00, 0x00,
 }
 
 func (m *Delta) Marshal() (dAtA []byte, err error) {
@@ -237,6 +308,45 @@ func (m *Delta) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.SignedPeerRecord != nil {
+		i -= len(m.SignedPeerRecord)
+		copy(dAtA[i:], m.SignedPeerRecord)
+		i = encodeVarintIdentify(dAtA, i, uint64(len(m.SignedPeerRecord)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if m.ProtocolVersion != nil {
+		i -= len(*m.ProtocolVersion)
+		copy(dAtA[i:], *m.ProtocolVersion)
+		i = encodeVarintIdentify(dAtA, i, uint64(len(*m.ProtocolVersion)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.AgentVersion != nil {
+		i -= len(*m.AgentVersion)
+		copy(dAtA[i:], *m.AgentVersion)
+		i = encodeVarintIdentify(dAtA, i, uint64(len(*m.AgentVersion)))
+		i--
+		dAtA[i] = 0x2a
+	}
+	if len(m.RmAddrs) > 0 {
+		for iNdEx := len(m.RmAddrs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.RmAddrs[iNdEx])
+			copy(dAtA[i:], m.RmAddrs[iNdEx])
+			i = encodeVarintIdentify(dAtA, i, uint64(len(m.RmAddrs[iNdEx])))
+			i--
+			dAtA[i] = 0x22
+		}
+	}
+	if len(m.AddedAddrs) > 0 {
+		for iNdEx := len(m.AddedAddrs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.AddedAddrs[iNdEx])
+			copy(dAtA[i:], m.AddedAddrs[iNdEx])
+			i = encodeVarintIdentify(dAtA, i, uint64(len(m.AddedAddrs[iNdEx])))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
 	if len(m.RmProtocols) > 0 {
 		for iNdEx := len(m.RmProtocols) - 1; iNdEx >= 0; iNdEx-- {
 			i -= len(m.RmProtocols[iNdEx])
@@ -282,6 +392,23 @@ func (m *Identify) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.More != nil {
+		i--
+		if *m.More {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.SignedPeerRecord != nil {
+		i -= len(m.SignedPeerRecord)
+		copy(dAtA[i:], m.SignedPeerRecord)
+		i = encodeVarintIdentify(dAtA, i, uint64(len(m.SignedPeerRecord)))
+		i--
+		dAtA[i] = 0x42
+	}
 	if m.Delta != nil {
 		{
 			size, err := m.Delta.MarshalToSizedBuffer(dAtA[:i])
@@ -372,6 +499,30 @@ func (m *Delta) Size() (n int) {
 			n += 1 + l + sovIdentify(uint64(l))
 		}
 	}
+	if len(m.AddedAddrs) > 0 {
+		for _, b := range m.AddedAddrs {
+			l = len(b)
+			n += 1 + l + sovIdentify(uint64(l))
+		}
+	}
+	if len(m.RmAddrs) > 0 {
+		for _, b := range m.RmAddrs {
+			l = len(b)
+			n += 1 + l + sovIdentify(uint64(l))
+		}
+	}
+	if m.AgentVersion != nil {
+		l = len(*m.AgentVersion)
+		n += 1 + l + sovIdentify(uint64(l))
+	}
+	if m.ProtocolVersion != nil {
+		l = len(*m.ProtocolVersion)
+		n += 1 + l + sovIdentify(uint64(l))
+	}
+	if m.SignedPeerRecord != nil {
+		l = len(m.SignedPeerRecord)
+		n += 1 + l + sovIdentify(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -416,6 +567,13 @@ func (m *Identify) Size() (n int) {
 		l = m.Delta.Size()
 		n += 1 + l + sovIdentify(uint64(l))
 	}
+	if m.SignedPeerRecord != nil {
+		l = len(m.SignedPeerRecord)
+		n += 1 + l + sovIdentify(uint64(l))
+	}
+	if m.More != nil {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -521,6 +679,170 @@ func (m *Delta) Unmarshal(dAtA []byte) error {
 			}
 			m.RmProtocols = append(m.RmProtocols, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AddedAddrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.AddedAddrs = append(m.AddedAddrs, make([]byte, postIndex-iNdEx))
+			copy(m.AddedAddrs[len(m.AddedAddrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RmAddrs", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.RmAddrs = append(m.RmAddrs, make([]byte, postIndex-iNdEx))
+			copy(m.RmAddrs[len(m.RmAddrs)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AgentVersion", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.AgentVersion = &s
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ProtocolVersion", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(dAtA[iNdEx:postIndex])
+			m.ProtocolVersion = &s
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignedPeerRecord", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignedPeerRecord = append(m.SignedPeerRecord[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignedPeerRecord == nil {
+				m.SignedPeerRecord = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipIdentify(dAtA[iNdEx:])
@@ -809,6 +1131,61 @@ func (m *Identify) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SignedPeerRecord", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthIdentify
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SignedPeerRecord = append(m.SignedPeerRecord[:0], dAtA[iNdEx:postIndex]...)
+			if m.SignedPeerRecord == nil {
+				m.SignedPeerRecord = []byte{}
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field More", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowIdentify
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			b := bool(v != 0)
+			m.More = &b
 		default:
 			iNdEx = preIndex
 			skippy, err := skipIdentify(dAtA[iNdEx:])
@@ -891,9 +1268,6 @@ func skipIdentify(dAtA []byte) (n int, err error) {
 				return 0, ErrInvalidLengthIdentify
 			}
 			iNdEx += length
-			if iNdEx < 0 {
-				return 0, ErrInvalidLengthIdentify
-			}
 		case 3:
 			depth++
 		case 4:
@@ -906,6 +1280,9 @@ func skipIdentify(dAtA []byte) (n int, err error) {
 		default:
 			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
 		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthIdentify
+		}
 		if depth == 0 {
 			return iNdEx, nil
 		}
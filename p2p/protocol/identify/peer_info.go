@@ -0,0 +1,151 @@
+package identify
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EvtPeerIdentified is emitted on the host's event bus right after the
+// event.EvtPeerIdentificationCompleted for a peer, carrying what the peer told
+// us about itself.
+//
+// Both events are emitted once per successful identification. Consumers
+// needing the peer's info should subscribe to this event rather than look it
+// up with PeerInfo on event.EvtPeerIdentificationCompleted; the others should
+// keep subscribing to event.EvtPeerIdentificationCompleted. Later updates
+// pushed by the peer don't emit it; PeerInfo returns the latest info.
+type EvtPeerIdentified struct {
+	// Peer is the ID of the peer whose identification succeeded.
+	Peer peer.ID
+	// Info is what the peer told us about itself.
+	Info PeerInfo
+}
+
+// PeerInfo is what a peer told us about itself through identify.
+type PeerInfo struct {
+	// ProtocolVersion is the version of the libp2p protocol the peer speaks.
+	ProtocolVersion string
+	// AgentVersion is the user agent of the peer.
+	AgentVersion string
+	// Protocols are the protocols the peer handles.
+	Protocols []protocol.ID
+	// ListenAddrs are the addresses the peer listens on. If the peer sent a
+	// signed peer record, these are the addresses it certifies.
+	ListenAddrs []ma.Multiaddr
+	// ObservedAddr is the address the peer observed us at, nil if it didn't
+	// tell us.
+	ObservedAddr ma.Multiaddr
+	// Time is when we last received identify data from the peer.
+	Time time.Time
+	// Conn is the connection we last received identify data on.
+	Conn network.Conn
+}
+
+// PeerInfo returns what the connected peer told us about itself, and false
+// if we haven't identified it.
+func (ids *IDService) PeerInfo(p peer.ID) (PeerInfo, bool) {
+	ids.infoMu.Lock()
+	defer ids.infoMu.Unlock()
+
+	info, ok := ids.infos[p]
+	if !ok {
+		return PeerInfo{}, false
+	}
+	out := *info
+	out.Protocols = append([]protocol.ID(nil), info.Protocols...)
+	out.ListenAddrs = append([]ma.Multiaddr(nil), info.ListenAddrs...)
+	return out, true
+}
+
+// updatePeerInfo applies the identify data received on the connection to the
+// info of the peer.
+func (ids *IDService) updatePeerInfo(c network.Conn, update func(info *PeerInfo)) {
+	p := c.RemotePeer()
+	ids.infoMu.Lock()
+	defer ids.infoMu.Unlock()
+
+	// the info is forgotten when the peer disconnects, see
+	// netNotifiee.Disconnected.
+	if ids.Host.Network().Connectedness(p) != network.Connected {
+		return
+	}
+	info, ok := ids.infos[p]
+	if !ok {
+		info = new(PeerInfo)
+		ids.infos[p] = info
+	}
+	update(info)
+	info.Time = time.Now()
+	info.Conn = c
+}
+
+// forgetPeerInfo removes the info of the peer once it's disconnected.
+func (ids *IDService) forgetPeerInfo(p peer.ID) {
+	ids.infoMu.Lock()
+	defer ids.infoMu.Unlock()
+
+	if ids.Host.Network().Connectedness(p) != network.Connected {
+		delete(ids.infos, p)
+	}
+}
+
+func addProtocols(protos []protocol.ID, added []string) []protocol.ID {
+	have := make(map[protocol.ID]struct{}, len(protos))
+	for _, p := range protos {
+		have[p] = struct{}{}
+	}
+	for _, p := range protocol.ConvertFromStrings(added) {
+		if _, ok := have[p]; !ok {
+			have[p] = struct{}{}
+			protos = append(protos, p)
+		}
+	}
+	return protos
+}
+
+func removeProtocols(protos []protocol.ID, removed []string) []protocol.ID {
+	rm := make(map[protocol.ID]struct{}, len(removed))
+	for _, p := range protocol.ConvertFromStrings(removed) {
+		rm[p] = struct{}{}
+	}
+	out := protos[:0]
+	for _, p := range protos {
+		if _, ok := rm[p]; !ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func addAddrs(addrs, added []ma.Multiaddr) []ma.Multiaddr {
+	for _, a := range added {
+		if !containsAddr(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+func removeAddrs(addrs, removed []ma.Multiaddr) []ma.Multiaddr {
+	out := addrs[:0]
+	for _, a := range addrs {
+		if !containsAddr(removed, a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func containsAddr(addrs []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package identify
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/record"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// signedPeerRecordKey is the peerstore metadata key the latest signed peer
+// record of a peer is stored under when the peerstore is not a
+// CertifiedAddrBook. This is a fallback: the addresses of the record still
+// replace the peer's unsigned addresses in the address book, but they aren't
+// marked as certified there; SignedPeerRecord returns the record itself.
+const signedPeerRecordKey = "SignedPeerRecord"
+
+// signedRecord is a peer record we signed for a set of our addresses.
+type signedRecord struct {
+	addrs []ma.Multiaddr
+	env   []byte
+}
+
+// signedPeerRecord returns our serialized, signed peer record for the given
+// addresses. Records are cached until the addresses change, so we don't
+// sign them for every identify.
+//
+// Peers on loopback connections are sent our loopback addresses, the others
+// are not, so there are two records. Both are signed with the same sequence
+// number, which only increases when our addresses change: a peer receiving
+// both never sees the sequence number go backwards.
+func (ids *IDService) signedPeerRecord(addrs []ma.Multiaddr, viaLoopback bool) ([]byte, error) {
+	ids.recordMu.Lock()
+	defer ids.recordMu.Unlock()
+
+	cached := ids.records[viaLoopback]
+	if cached != nil && sameAddrs(cached.addrs, addrs) {
+		return cached.env, nil
+	}
+
+	sk := ids.Host.Peerstore().PrivKey(ids.Host.ID())
+	if sk == nil {
+		// we're using an insecure transport, there's nothing to sign with.
+		return nil, nil
+	}
+
+	// our addresses changed since the cached record was signed, the records
+	// signed with the old sequence number are stale.
+	if cached != nil || ids.recordSeq == 0 {
+		ids.recordSeq = nextSeq(ids.recordSeq)
+		ids.records = make(map[bool]*signedRecord, 2)
+	}
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: ids.Host.ID(), Addrs: addrs})
+	rec.Seq = ids.recordSeq
+	env, err := record.Seal(rec, sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign peer record: %s", err)
+	}
+	envBytes, err := env.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal peer record: %s", err)
+	}
+
+	ids.records[viaLoopback] = &signedRecord{addrs: addrs, env: envBytes}
+	return envBytes, nil
+}
+
+// nextSeq returns the sequence number following seq. Like
+// peer.TimestampSeq, it's based on the current time, so that it keeps
+// increasing across restarts.
+func nextSeq(seq uint64) uint64 {
+	if now := uint64(time.Now().UnixNano()); now > seq {
+		return now
+	}
+	return seq + 1
+}
+
+// consumeSignedPeerRecord verifies the signed peer record received on the
+// connection and stores it, in the peerstore's CertifiedAddrBook if it
+// implements one and in the peer's metadata otherwise. It returns the
+// certified addresses of the peer, and false if the record is invalid.
+func (ids *IDService) consumeSignedPeerRecord(c network.Conn, data []byte, ttl time.Duration) ([]ma.Multiaddr, bool) {
+	p := c.RemotePeer()
+	env, rec, err := verifyPeerRecord(c, data)
+	if err != nil {
+		log.Warningf("%s received invalid peer record from %s: %s", c.LocalPeer(), p, err)
+		return nil, false
+	}
+
+	pstore := ids.Host.Peerstore()
+	if cab, ok := peerstore.GetCertifiedAddrBook(pstore); ok {
+		if _, err := cab.ConsumePeerRecord(env, ttl); err != nil {
+			log.Warningf("%s could not store peer record of %s: %s", c.LocalPeer(), p, err)
+			return nil, false
+		}
+		// the peerstore keeps the addresses of the latest record.
+		return pstore.Addrs(p), true
+	}
+
+	// keep the record with the highest sequence number; the addresses of an
+	// older one are stale.
+	if _, prev := ids.peerRecord(p); prev != nil && prev.Seq >= rec.Seq {
+		if prev.Seq > rec.Seq {
+			log.Debugf("%s received outdated peer record from %s", c.LocalPeer(), p)
+		}
+		return prev.Addrs, true
+	}
+	if err := pstore.Put(p, signedPeerRecordKey, data); err != nil {
+		log.Debugf("%s could not store peer record of %s: %s", c.LocalPeer(), p, err)
+	}
+	return rec.Addrs, true
+}
+
+// verifyPeerRecord checks that the envelope contains a peer record of the
+// remote peer, signed with the key of the connection.
+func verifyPeerRecord(c network.Conn, data []byte) (*record.Envelope, *peer.PeerRecord, error) {
+	rec := &peer.PeerRecord{}
+	env, err := record.ConsumeTypedEnvelope(data, rec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get peer.ID from signing key: %s", err)
+	}
+	if signer != c.RemotePeer() {
+		return nil, nil, fmt.Errorf("record signed by %s", signer)
+	}
+	if rec.PeerID != c.RemotePeer() {
+		return nil, nil, fmt.Errorf("record of %s", rec.PeerID)
+	}
+	if key := c.RemotePublicKey(); key != nil && !key.Equals(env.PublicKey) {
+		return nil, nil, fmt.Errorf("record not signed with the key of the connection")
+	}
+	return env, rec, nil
+}
+
+// SignedPeerRecord returns the latest signed peer record received from the
+// peer, or nil if there's none. It's read from the peerstore's
+// CertifiedAddrBook if it implements one, and from the fallback peer metadata
+// otherwise.
+func (ids *IDService) SignedPeerRecord(p peer.ID) *record.Envelope {
+	if cab, ok := peerstore.GetCertifiedAddrBook(ids.Host.Peerstore()); ok {
+		return cab.GetPeerRecord(p)
+	}
+	env, _ := ids.peerRecord(p)
+	return env
+}
+
+// peerRecord returns the signed peer record of the peer stored in the
+// peerstore metadata.
+func (ids *IDService) peerRecord(p peer.ID) (*record.Envelope, *peer.PeerRecord) {
+	v, err := ids.Host.Peerstore().Get(p, signedPeerRecordKey)
+	if err != nil {
+		return nil, nil
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, nil
+	}
+	rec := &peer.PeerRecord{}
+	env, err := record.ConsumeTypedEnvelope(data, rec)
+	if err != nil {
+		return nil, nil
+	}
+	return env, rec
+}
+
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Bytes(), b[i].Bytes()) {
+			return false
+		}
+	}
+	return true
+}
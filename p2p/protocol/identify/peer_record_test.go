@@ -0,0 +1,57 @@
+package identify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/record"
+
+	blhost "github.com/libp2p/go-libp2p-blankhost"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSignedPeerRecordSeq(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := blhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+	defer h.Close()
+	ids := &IDService{Host: h}
+
+	seq := func(addrs []ma.Multiaddr, viaLoopback bool) uint64 {
+		t.Helper()
+		data, err := ids.signedPeerRecord(addrs, viaLoopback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := &peer.PeerRecord{}
+		if _, err := record.ConsumeTypedEnvelope(data, rec); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Seq
+	}
+
+	public := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/1")}
+	loopback := append(public, ma.StringCast("/ip4/127.0.0.1/tcp/1"))
+
+	first := seq(public, false)
+	if s := seq(loopback, true); s != first {
+		t.Errorf("expected both records to have seq %d, got %d", first, s)
+	}
+	if s := seq(public, false); s != first {
+		t.Errorf("expected the cached record with seq %d, got %d", first, s)
+	}
+
+	// the loopback record is re-signed once our addresses changed, with the
+	// new sequence number.
+	changed := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/2")}
+	second := seq(changed, false)
+	if second <= first {
+		t.Errorf("expected the seq to increase, got %d after %d", second, first)
+	}
+	if s := seq(append(changed, loopback[1]), true); s != second {
+		t.Errorf("expected the loopback record to have seq %d, got %d", second, s)
+	}
+}
@@ -0,0 +1,284 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/helpers"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/gogo/protobuf/proto"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/rpc/pb"
+)
+
+// Call calls a unary method of the given peer, unmarshalling the response
+// into resp. If the context is canceled or its deadline expires, the call's
+// stream is reset, which also cancels the remote handler's context.
+//
+// Errors returned by the remote handler are returned as *Error.
+func (s *Service) Call(ctx context.Context, p peer.ID, method string, req, resp proto.Message) error {
+	cs, err := s.CallStream(ctx, p, method, req)
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+
+	switch err := cs.Recv(resp); err {
+	case nil:
+	case io.EOF:
+		return Errorf(Internal, "%s returned no response", method)
+	default:
+		return err
+	}
+	if err := cs.recv(nil); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// CallStream calls a streaming method of the given peer. It returns once the
+// peer responded, after which the responses are read with Recv. The context
+// applies to the whole call.
+func (s *Service) CallStream(ctx context.Context, p peer.ID, method string, req proto.Message) (*ClientStream, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, Errorf(Internal, "marshalling request: %s", err)
+	}
+	preq := &pb.Request{Method: proto.String(method), Payload: payload}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		if timeout < time.Millisecond {
+			timeout = time.Millisecond
+		}
+		preq.Timeout = proto.Uint64(uint64(timeout / time.Millisecond))
+	}
+	if preq.Size() > s.cfg.maxMessageSize {
+		return nil, Errorf(ResourceExhausted, "request of %d bytes exceeds the maximum message size", preq.Size())
+	}
+
+	for {
+		st, reused, err := s.getStream(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		cs := &ClientStream{
+			svc:  s,
+			p:    p,
+			ctx:  ctx,
+			st:   st,
+			stop: make(chan struct{}),
+		}
+		go cs.watch()
+
+		err = st.w.WriteMsg(preq)
+		if err == nil {
+			cs.next = new(pb.Response)
+			err = st.r.ReadMsg(cs.next)
+		}
+		if err == io.EOF && reused {
+			// The peer closed the idle stream before it got our request.
+			cs.stopWatch()
+			st.s.Reset()
+			continue
+		}
+		if err != nil {
+			cs.fail(err)
+			return nil, cs.err
+		}
+		return cs, nil
+	}
+}
+
+// ClientStream is a call to a streaming method. It must not be used
+// concurrently.
+type ClientStream struct {
+	svc *Service
+	p   peer.ID
+	ctx context.Context
+	st  *rpcStream
+
+	// next is the first response, read by CallStream.
+	next *pb.Response
+	// err is set once the call is done; io.EOF if it succeeded.
+	err error
+
+	mx      sync.Mutex
+	stop    chan struct{}
+	stopped bool
+	reset   bool
+}
+
+// Recv reads the next response into msg. It returns io.EOF once the call
+// completed successfully, and the call's error if it failed.
+func (cs *ClientStream) Recv(msg proto.Message) error {
+	return cs.recv(msg)
+}
+
+// Close cancels the call if it's still running.
+func (cs *ClientStream) Close() error {
+	if cs.err == nil {
+		cs.fail(Errorf(Canceled, "call closed"))
+	}
+	return nil
+}
+
+// recv reads the next response into msg, or the trailer if msg is nil.
+func (cs *ClientStream) recv(msg proto.Message) error {
+	if cs.err != nil {
+		return cs.err
+	}
+
+	resp := cs.next
+	cs.next = nil
+	if resp == nil {
+		resp = new(pb.Response)
+		if err := cs.st.r.ReadMsg(resp); err != nil {
+			cs.fail(err)
+			return cs.err
+		}
+	}
+
+	switch {
+	case resp.Trailer != nil:
+		cs.finish(resp.Trailer)
+	case msg == nil:
+		cs.fail(Errorf(Internal, "unexpected response"))
+	default:
+		if err := proto.Unmarshal(resp.Payload, msg); err != nil {
+			cs.fail(Errorf(Internal, "unmarshalling response: %s", err))
+			return cs.err
+		}
+		return nil
+	}
+	return cs.err
+}
+
+// watch resets the stream when the context is done.
+func (cs *ClientStream) watch() {
+	select {
+	case <-cs.ctx.Done():
+		cs.mx.Lock()
+		if !cs.stopped {
+			cs.reset = true
+			cs.st.s.Reset()
+		}
+		cs.mx.Unlock()
+	case <-cs.stop:
+	}
+}
+
+// stopWatch stops watching the context and returns true if the stream was
+// reset because the context was done.
+func (cs *ClientStream) stopWatch() bool {
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+	cs.stopped = true
+	close(cs.stop)
+	return cs.reset
+}
+
+// fail ends the call with the given error, resetting the stream.
+func (cs *ClientStream) fail(err error) {
+	if cs.stopWatch() {
+		err = cs.ctx.Err()
+	} else {
+		cs.st.s.Reset()
+	}
+	cs.err = err
+}
+
+// finish ends the call with the given trailer, returning the stream to the
+// pool.
+func (cs *ClientStream) finish(trailer *pb.Trailer) {
+	if cs.stopWatch() {
+		cs.err = cs.ctx.Err()
+		return
+	}
+	cs.svc.putStream(cs.p, cs.st)
+
+	if code := Code(trailer.GetCode()); code != OK {
+		cs.err = &Error{Code: code, Message: trailer.GetMessage()}
+	} else {
+		cs.err = io.EOF
+	}
+}
+
+// getStream returns a pooled stream to the peer or opens a new one. It
+// returns true if the stream was pooled.
+func (s *Service) getStream(ctx context.Context, p peer.ID) (*rpcStream, bool, error) {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return nil, false, Errorf(Unavailable, "service closed")
+	}
+	for idle := s.idle[p]; len(idle) > 0; idle = s.idle[p] {
+		st := idle[len(idle)-1]
+		if len(idle) == 1 {
+			delete(s.idle, p)
+		} else {
+			s.idle[p] = idle[:len(idle)-1]
+		}
+		if time.Since(st.used) < s.cfg.idleTimeout {
+			s.mx.Unlock()
+			return st, true, nil
+		}
+		go helpers.FullClose(st.s)
+	}
+	s.mx.Unlock()
+
+	ns, err := s.host.NewStream(ctx, p, s.proto)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.newRPCStream(ns), false, nil
+}
+
+// putStream returns the stream of a completed call to the pool.
+func (s *Service) putStream(p peer.ID, st *rpcStream) {
+	st.used = time.Now()
+
+	s.mx.Lock()
+	if !s.closed && len(s.idle[p]) < s.cfg.maxIdleStreams {
+		s.idle[p] = append(s.idle[p], st)
+		s.mx.Unlock()
+		return
+	}
+	s.mx.Unlock()
+	go helpers.FullClose(st.s)
+}
+
+// dropIdle resets the pooled streams of a closed connection.
+func (s *Service) dropIdle(c network.Conn) {
+	p := c.RemotePeer()
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	idle := s.idle[p]
+	kept := idle[:0]
+	for _, st := range idle {
+		if st.s.Conn() == c {
+			st.s.Reset()
+		} else {
+			kept = append(kept, st)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.idle, p)
+	} else {
+		s.idle[p] = kept
+	}
+}
+
+// IdleStreams returns the number of pooled streams to the given peer.
+func (s *Service) IdleStreams(p peer.ID) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.idle[p])
+}
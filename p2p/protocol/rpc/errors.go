@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Code is an error code carried in the trailer of a call. The codes match the
+// gRPC status codes.
+type Code uint32
+
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	Unknown           Code = 2
+	InvalidArgument   Code = 3
+	DeadlineExceeded  Code = 4
+	NotFound          Code = 5
+	PermissionDenied  Code = 7
+	ResourceExhausted Code = 8
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+)
+
+var codeNames = map[Code]string{
+	OK:                "ok",
+	Canceled:          "canceled",
+	Unknown:           "unknown",
+	InvalidArgument:   "invalid argument",
+	DeadlineExceeded:  "deadline exceeded",
+	NotFound:          "not found",
+	PermissionDenied:  "permission denied",
+	ResourceExhausted: "resource exhausted",
+	Unimplemented:     "unimplemented",
+	Internal:          "internal",
+	Unavailable:       "unavailable",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code(%d)", uint32(c))
+}
+
+// Error is an error with a code. Handlers return it to choose the code sent
+// to the caller, and calls return it when the remote handler failed.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error (%s): %s", e.Code, e.Message)
+}
+
+// Errorf returns an *Error with the given code and formatted message.
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode returns the code of the given error: OK for nil, the code of an
+// *Error, Canceled and DeadlineExceeded for the context errors and Unknown
+// otherwise.
+func ErrorCode(err error) Code {
+	switch err {
+	case nil:
+		return OK
+	case context.Canceled:
+		return Canceled
+	case context.DeadlineExceeded:
+		return DeadlineExceeded
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return Unknown
+}
@@ -0,0 +1,35 @@
+package rpc
+
+import "time"
+
+type config struct {
+	maxMessageSize int
+	maxIdleStreams int
+	idleTimeout    time.Duration
+}
+
+// Option is an option function for rpc services.
+type Option func(*config)
+
+// MaxMessageSize sets the maximum size of request and response messages,
+// including their envelope.
+func MaxMessageSize(n int) Option {
+	return func(cfg *config) {
+		cfg.maxMessageSize = n
+	}
+}
+
+// MaxIdleStreams sets the maximum number of idle streams pooled per peer. 0
+// disables pooling.
+func MaxIdleStreams(n int) Option {
+	return func(cfg *config) {
+		cfg.maxIdleStreams = n
+	}
+}
+
+// IdleTimeout sets the time after which idle streams are closed.
+func IdleTimeout(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.idleTimeout = d
+	}
+}
@@ -0,0 +1,306 @@
+// Package rpc implements request/response calls over libp2p streams.
+//
+// A Service serves and calls the methods of a single protocol. Unary methods
+// respond with exactly one message, streaming methods with any number of
+// messages. Messages are length-delimited protobufs. Every call ends with a
+// trailer carrying an error code (see Code), and the streams of completed
+// calls are pooled and reused for later calls to the same peer.
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ggio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/rpc/pb"
+)
+
+var log = logging.Logger("rpc")
+
+const (
+	// DefaultMaxMessageSize is the default maximum size of a request or
+	// response message.
+	DefaultMaxMessageSize = 4 << 20
+	// DefaultMaxIdleStreams is the default maximum number of idle streams
+	// pooled per peer.
+	DefaultMaxIdleStreams = 4
+	// DefaultIdleTimeout is the default time after which idle streams are
+	// closed.
+	DefaultIdleTimeout = time.Minute
+)
+
+// UnaryHandler handles a call to a unary method, returning the response
+// message. Returning an *Error sets the code sent to the caller.
+type UnaryHandler func(ctx context.Context, p peer.ID, req proto.Message) (proto.Message, error)
+
+// StreamHandler handles a call to a streaming method, sending the response
+// messages with send. The context is canceled if the caller cancels the
+// call.
+type StreamHandler func(ctx context.Context, p peer.ID, req proto.Message, send func(proto.Message) error) error
+
+type method struct {
+	newReq func() proto.Message
+	unary  UnaryHandler
+	stream StreamHandler
+}
+
+// Service serves and calls the methods of a protocol.
+type Service struct {
+	host  host.Host
+	proto protocol.ID
+	cfg   config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mx      sync.Mutex
+	methods map[string]*method
+	idle    map[peer.ID][]*rpcStream
+	closed  bool
+
+	notifiee network.Notifiee
+}
+
+// NewService constructs a service for the given protocol and sets its stream
+// handler on the host.
+func NewService(h host.Host, pid protocol.ID, opts ...Option) *Service {
+	cfg := config{
+		maxMessageSize: DefaultMaxMessageSize,
+		maxIdleStreams: DefaultMaxIdleStreams,
+		idleTimeout:    DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := &Service{
+		host:    h,
+		proto:   pid,
+		cfg:     cfg,
+		methods: make(map[string]*method),
+		idle:    make(map[peer.ID][]*rpcStream),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.notifiee = &network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, c network.Conn) { s.dropIdle(c) },
+	}
+
+	h.Network().Notify(s.notifiee)
+	h.SetStreamHandler(pid, s.handleStream)
+	return s
+}
+
+// Handle registers a unary method. Requests are unmarshalled into the
+// messages returned by newReq.
+func (s *Service) Handle(name string, newReq func() proto.Message, handler UnaryHandler) {
+	s.addMethod(name, &method{newReq: newReq, unary: handler})
+}
+
+// HandleStream registers a streaming method. Requests are unmarshalled into
+// the messages returned by newReq.
+func (s *Service) HandleStream(name string, newReq func() proto.Message, handler StreamHandler) {
+	s.addMethod(name, &method{newReq: newReq, stream: handler})
+}
+
+func (s *Service) addMethod(name string, m *method) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.methods[name] = m
+}
+
+// Close removes the service's stream handler, cancels the running handlers
+// and resets the pooled streams.
+func (s *Service) Close() error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return nil
+	}
+	s.closed = true
+	idle := s.idle
+	s.idle = nil
+	s.mx.Unlock()
+
+	s.host.RemoveStreamHandler(s.proto)
+	s.host.Network().StopNotify(s.notifiee)
+	s.cancel()
+	for _, sts := range idle {
+		for _, st := range sts {
+			st.s.Reset()
+		}
+	}
+	return nil
+}
+
+// rpcStream is a stream with its message reader and writer, which must
+// outlive single calls as the reader is buffered.
+type rpcStream struct {
+	s    network.Stream
+	r    ggio.ReadCloser
+	w    ggio.WriteCloser
+	used time.Time
+}
+
+func (s *Service) newRPCStream(ns network.Stream) *rpcStream {
+	return &rpcStream{
+		s: ns,
+		r: ggio.NewDelimitedReader(ns, s.cfg.maxMessageSize),
+		w: ggio.NewDelimitedWriter(ns),
+	}
+}
+
+// handleStream serves the calls made on an inbound stream, one at a time.
+func (s *Service) handleStream(ns network.Stream) {
+	st := s.newRPCStream(ns)
+	p := ns.Conn().RemotePeer()
+
+	reqs := make(chan *pb.Request)
+	// gone is closed when the stream can't be read anymore, canceling the
+	// running call.
+	gone := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		defer close(gone)
+		for {
+			req := new(pb.Request)
+			if err := st.r.ReadMsg(req); err != nil {
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	idle := time.NewTimer(s.cfg.idleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case req := <-reqs:
+			if !s.serve(st, p, req, gone) {
+				ns.Reset()
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(s.cfg.idleTimeout)
+		case <-gone:
+			ns.Close()
+			return
+		case <-idle.C:
+			log.Debugf("closing idle %s stream from %s", s.proto, p)
+			ns.Close()
+			return
+		case <-s.ctx.Done():
+			ns.Reset()
+			return
+		}
+	}
+}
+
+// serve handles a call. It returns false if the stream broke.
+func (s *Service) serve(st *rpcStream, p peer.ID, req *pb.Request, gone <-chan struct{}) bool {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if t := req.GetTimeout(); t > 0 {
+		ctx, cancel = context.WithTimeout(s.ctx, time.Duration(t)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(s.ctx)
+	}
+	defer cancel()
+
+	go func() {
+		select {
+		case <-gone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	broken := false
+	send := func(msg proto.Message) error {
+		if broken {
+			return Errorf(Canceled, "stream closed")
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		payload, err := proto.Marshal(msg)
+		if err != nil {
+			return Errorf(Internal, "marshalling response: %s", err)
+		}
+		resp := &pb.Response{Payload: payload}
+		if resp.Size() > s.cfg.maxMessageSize {
+			return Errorf(ResourceExhausted, "response of %d bytes exceeds the maximum message size", resp.Size())
+		}
+		if err := st.w.WriteMsg(resp); err != nil {
+			broken = true
+			return err
+		}
+		return nil
+	}
+
+	err := s.call(ctx, p, req, send)
+	if broken {
+		return false
+	}
+	select {
+	case <-gone:
+		return false
+	default:
+	}
+
+	trailer := &pb.Trailer{}
+	if code := ErrorCode(err); code != OK {
+		trailer.Code = proto.Uint32(uint32(code))
+		if e, ok := err.(*Error); ok {
+			trailer.Message = proto.String(e.Message)
+		} else {
+			trailer.Message = proto.String(err.Error())
+		}
+	}
+	if err := st.w.WriteMsg(&pb.Response{Trailer: trailer}); err != nil {
+		log.Debugf("error writing %s trailer to %s: %s", s.proto, p, err)
+		return false
+	}
+	return true
+}
+
+// call runs the handler of the requested method.
+func (s *Service) call(ctx context.Context, p peer.ID, req *pb.Request, send func(proto.Message) error) error {
+	s.mx.Lock()
+	m, ok := s.methods[req.GetMethod()]
+	s.mx.Unlock()
+	if !ok {
+		return Errorf(Unimplemented, "unknown method %s", req.GetMethod())
+	}
+
+	msg := m.newReq()
+	if err := proto.Unmarshal(req.Payload, msg); err != nil {
+		return Errorf(InvalidArgument, "unmarshalling request: %s", err)
+	}
+
+	if m.stream != nil {
+		return m.stream(ctx, p, msg, send)
+	}
+	resp, err := m.unary(ctx, p, msg)
+	if err != nil {
+		return err
+	}
+	return send(resp)
+}
@@ -0,0 +1,202 @@
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/gogo/protobuf/proto"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	rpc "github.com/libp2p/go-libp2p/p2p/protocol/rpc"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/rpc/pb"
+)
+
+const testProto = "/test/rpc/1.0.0"
+
+// The tests use trailers as request and response messages.
+func newMsg() proto.Message { return new(pb.Trailer) }
+
+func msg(s string) *pb.Trailer { return &pb.Trailer{Message: proto.String(s)} }
+
+func getHostPair(ctx context.Context, t *testing.T) (host.Host, host.Host) {
+	t.Helper()
+	h1 := bhost.New(swarmt.GenSwarm(t, ctx))
+	h2 := bhost.New(swarmt.GenSwarm(t, ctx))
+	if err := h1.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	return h1, h2
+}
+
+func echo(ctx context.Context, p peer.ID, req proto.Message) (proto.Message, error) {
+	return msg("echo " + req.(*pb.Trailer).GetMessage()), nil
+}
+
+func TestUnaryCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	server := rpc.NewService(h1, testProto)
+	defer server.Close()
+	server.Handle("echo", newMsg, echo)
+	server.Handle("fail", newMsg, func(context.Context, peer.ID, proto.Message) (proto.Message, error) {
+		return nil, rpc.Errorf(rpc.NotFound, "nothing here")
+	})
+
+	client := rpc.NewService(h2, testProto)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		var resp pb.Trailer
+		if err := client.Call(ctx, h1.ID(), "echo", msg(fmt.Sprint(i)), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetMessage() != fmt.Sprintf("echo %d", i) {
+			t.Fatalf("unexpected response %q", resp.GetMessage())
+		}
+	}
+
+	err := client.Call(ctx, h1.ID(), "fail", msg("x"), new(pb.Trailer))
+	if e, ok := err.(*rpc.Error); !ok || e.Code != rpc.NotFound || e.Message != "nothing here" {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if err := client.Call(ctx, h1.ID(), "missing", msg("x"), new(pb.Trailer)); rpc.ErrorCode(err) != rpc.Unimplemented {
+		t.Fatalf("expected an unimplemented error, got %v", err)
+	}
+
+	// all calls ran on the same stream
+	if n := client.IdleStreams(h1.ID()); n != 1 {
+		t.Errorf("expected 1 idle stream, got %d", n)
+	}
+	if n := len(h2.Network().ConnsToPeer(h1.ID())[0].GetStreams()); n != 1 {
+		t.Errorf("expected 1 open stream, got %d", n)
+	}
+}
+
+func TestStreamCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	server := rpc.NewService(h1, testProto)
+	defer server.Close()
+	server.HandleStream("count", newMsg, func(ctx context.Context, p peer.ID, req proto.Message, send func(proto.Message) error) error {
+		for i := 0; i < 3; i++ {
+			if err := send(msg(fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		if req.(*pb.Trailer).GetMessage() == "fail" {
+			return rpc.Errorf(rpc.Internal, "failed")
+		}
+		return nil
+	})
+
+	client := rpc.NewService(h2, testProto)
+	defer client.Close()
+
+	for _, fail := range []bool{false, true} {
+		req := msg("")
+		if fail {
+			req = msg("fail")
+		}
+		cs, err := client.CallStream(ctx, h1.ID(), "count", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			var resp pb.Trailer
+			if err := cs.Recv(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetMessage() != fmt.Sprint(i) {
+				t.Fatalf("unexpected response %q", resp.GetMessage())
+			}
+		}
+		err = cs.Recv(new(pb.Trailer))
+		if fail && rpc.ErrorCode(err) != rpc.Internal {
+			t.Errorf("expected an internal error, got %v", err)
+		} else if !fail && err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	canceled := make(chan error, 1)
+	server := rpc.NewService(h1, testProto)
+	defer server.Close()
+	server.Handle("block", newMsg, func(ctx context.Context, p peer.ID, req proto.Message) (proto.Message, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	server.Handle("echo", newMsg, echo)
+
+	client := rpc.NewService(h2, testProto)
+	defer client.Close()
+
+	cctx, ccancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer ccancel()
+	// depending on timing, the handler's deadline expires first.
+	if err := client.Call(cctx, h1.ID(), "block", msg("x"), new(pb.Trailer)); rpc.ErrorCode(err) != rpc.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler's context to be canceled")
+	}
+
+	// later calls still succeed
+	if err := client.Call(ctx, h1.ID(), "echo", msg("x"), new(pb.Trailer)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	server := rpc.NewService(h1, testProto, rpc.IdleTimeout(50*time.Millisecond))
+	defer server.Close()
+	server.Handle("echo", newMsg, echo)
+
+	client := rpc.NewService(h2, testProto)
+	defer client.Close()
+
+	if err := client.Call(ctx, h1.ID(), "echo", msg("x"), new(pb.Trailer)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// the server closed the pooled stream, so the call is retried on a new
+	// one.
+	if err := client.Call(ctx, h1.ID(), "echo", msg("x"), new(pb.Trailer)); err != nil {
+		t.Fatal(err)
+	}
+}